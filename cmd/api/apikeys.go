@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+func (app *application) createAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string   `json:"name"`
+		Permissions   []string `json:"permissions"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	owned, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	apiKey := &data.Token{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	data.ValidateAPIKey(v, apiKey)
+
+	// A key may only be given permissions that its owner holds.
+	for _, code := range apiKey.Permissions {
+		v.Check(owned.Include(code), "permissions", fmt.Sprintf("%q is not a permission you hold", code))
+	}
+
+	// An expiry of zero days creates a key that never expires.
+	v.Check(input.ExpiresInDays >= 0, "expires_in_days", "must not be negative")
+	v.Check(input.ExpiresInDays <= 3650, "expires_in_days", "must be a maximum of 3650 days")
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	timeToLive := time.Duration(input.ExpiresInDays) * 24 * time.Hour
+
+	apiKey, err = app.models.Tokens.NewAPIKey(user.ID, timeToLive, apiKey.Name, apiKey.Permissions)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// The plaintext key is only ever included in this response.
+	err = app.writeJSON(rw, http.StatusCreated, envelope{"api_key": apiKey.Info()}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	apiKeys, err := app.models.Tokens.GetAllForUser(user.ID, data.ScopeAPIKey)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"api_keys": apiKeys}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDParam(r, "key_id")
+	if err != nil {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Tokens.DeleteForUser(id, user.ID, data.ScopeAPIKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// The authenticateCertificate() helper authenticates a request with the client
+// certificate that was verified during the TLS handshake, and passes it on to the next
+// handler as the user that the certificate is mapped to. A certificate that isn't mapped
+// to anyone is treated like a request without credentials, so clients that merely
+// happen to present one can still reach the public endpoints.
+func (app *application) authenticateCertificate(rw http.ResponseWriter, r *http.Request, next http.Handler) {
+	cert := r.TLS.VerifiedChains[0][0]
+
+	user, err := app.models.Certificates.GetUser(data.CertificateSubjects(cert))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			r = app.contextSetUser(r, data.AnonymousUser)
+
+			next.ServeHTTP(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	if user.IsSuspended() {
+		app.suspendedAccountResponse(rw, r)
+
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetToken(r, &data.Token{
+		UserID: user.ID,
+		Scope:  data.ScopeCertificate,
+	})
+
+	next.ServeHTTP(rw, r)
+}
+
+func (app *application) listCertificatesForUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	certificates, err := app.models.Certificates.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"certificates": certificates}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// addCertificateForUserHandler() maps a certificate subject to a user, so that clients
+// presenting a certificate with that subject are authenticated as the user.
+func (app *application) addCertificateForUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Subject string `json:"subject"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	certificate := &data.UserCertificate{
+		UserID:  user.ID,
+		Subject: input.Subject,
+	}
+
+	v := validator.New()
+	if data.ValidateUserCertificate(v, certificate); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Certificates.Insert(certificate)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCertificate):
+			v.AddError("subject", "is already mapped to a user")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusCreated, envelope{"certificate": certificate}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) removeCertificateForUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	id, err := app.readNamedIDParam(r, "certificate_id")
+	if err != nil {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	err = app.models.Certificates.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "certificate successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
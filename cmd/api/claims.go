@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/jwt"
+)
+
+// accessClaims holds the claims embedded in a signed authentication token. They carry
+// everything that authenticate() and requiresPermission() need, so that requests made
+// with a signed token can be authorized without querying the database.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Activated   bool             `json:"act"`
+	Permissions data.Permissions `json:"perms"`
+	Family      string           `json:"fam,omitempty"`
+}
+
+// signedToken holds a signed authentication token in the same shape that opaque tokens
+// are sent to the client in.
+type signedToken struct {
+	ID        string    `json:"-"`
+	PlainText string    `json:"token"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// The newSignedToken() helper issues a signed authentication token for the user, which
+// embeds their activation state and current permissions.
+func (app *application) newSignedToken(userID int64, family string) (*signedToken, error) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := jwt.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.tokens.accessTTL)
+
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiry.Unix(),
+		},
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Family:      family,
+	}
+
+	plainText, err := app.signingKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedToken{ID: id, PlainText: plainText, Expiry: expiry}, nil
+}
+
+// The verifySignedToken() helper checks a signed authentication token and returns its
+// claims. Tokens issued at or after the configured revocation cutoff are checked against
+// the in-memory copy of the revocation denylist, so that verifying a token doesn't need a
+// database query, and older tokens aren't checked at all.
+func (app *application) verifySignedToken(plainText string) (*accessClaims, error) {
+	var claims accessClaims
+
+	err := app.signingKeys.Verify(plainText, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := strconv.ParseInt(claims.Subject, 10, 64); err != nil || claims.ID == "" {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	if claims.IssuedAt >= app.config.tokens.revocationCutoff.Unix() && app.revocations.contains(claims.ID) {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// revocationCache holds the revocation denylist in memory, mapping the ID of each revoked
+// signed token to its expiry. Revoked tokens stay revoked until they expire, so entries
+// are only ever added until then.
+type revocationCache struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+}
+
+func newRevocationCache() *revocationCache {
+	return &revocationCache{entries: make(map[string]time.Time)}
+}
+
+// contains() returns true if the signed token with the given ID has been revoked.
+func (c *revocationCache) contains(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, found := c.entries[id]
+
+	return found
+}
+
+// add() records that a signed token has been revoked until the given expiry.
+func (c *revocationCache) add(id string, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[id] = expiry
+}
+
+// merge() adds the given entries to the cache, and removes the entries for tokens that
+// have expired anyway.
+func (c *revocationCache) merge(entries map[string]time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, expiry := range entries {
+		c.entries[id] = expiry
+	}
+
+	now := time.Now()
+
+	for id, expiry := range c.entries {
+		if expiry.Before(now) {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// The loadRevocations() helper reads the revocation denylist from the database into the
+// in-memory cache.
+func (app *application) loadRevocations() error {
+	entries, err := app.models.Revocations.GetAll()
+	if err != nil {
+		return err
+	}
+
+	app.revocations.merge(entries)
+
+	return nil
+}
+
+// The syncRevocations() helper reloads the revocation denylist after tokens have been
+// revoked in bulk, so that the revocations take effect on this instance straight away.
+// It does nothing unless signed tokens are in use. A failure is only logged, as the
+// revocations are stored and will be picked up by the next periodic reload anyway.
+func (app *application) syncRevocations() {
+	if app.revocations == nil {
+		return
+	}
+
+	err := app.loadRevocations()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// The revokeAllTokens() helper revokes every token of a user, along with their signed
+// authentication tokens, whenever they are locked out of their account. Deactivation,
+// suspension, deletion and erasure all revoke tokens in this same way.
+func (app *application) revokeAllTokens(userID int64) error {
+	err := app.models.Tokens.RevokeAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	app.syncRevocations()
+
+	return nil
+}
+
+// The reloadRevocations() helper reloads the revocation denylist at the given interval
+// for as long as the application runs, which is how revocations made by other instances
+// of the API reach this one.
+func (app *application) reloadRevocations(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		app.syncRevocations()
+	}
+}
+
+// userID returns the ID of the user that the claims were issued to.
+func (c *accessClaims) userID() int64 {
+	id, _ := strconv.ParseInt(c.Subject, 10, 64)
+
+	return id
+}
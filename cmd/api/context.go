@@ -10,7 +10,12 @@ import (
 // contextKey is a type alias with the underlying type string
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	claimsContextKey = contextKey("claims")
+	actorContextKey  = contextKey("actor")
+)
 
 // contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context.
@@ -31,3 +36,74 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetToken() returns a new copy of the request with the token that was used to
+// authenticate it added to the context.
+func (app *application) contextSetToken(r *http.Request, token *data.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+
+	return r.WithContext(ctx)
+}
+
+// contextGetToken() retrieves the token used to authenticate the request. Anonymous
+// requests carry no token, in which case nil is returned.
+func (app *application) contextGetToken(r *http.Request) *data.Token {
+	token, ok := r.Context().Value(tokenContextKey).(*data.Token)
+	if !ok {
+		return nil
+	}
+
+	return token
+}
+
+// contextSetClaims() returns a new copy of the request with the claims of the signed
+// token that was used to authenticate it added to the context.
+func (app *application) contextSetClaims(r *http.Request, claims *accessClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims() retrieves the claims of the signed token used to authenticate the
+// request, returning nil if the request wasn't authenticated with a signed token.
+func (app *application) contextGetClaims(r *http.Request) *accessClaims {
+	claims, ok := r.Context().Value(claimsContextKey).(*accessClaims)
+	if !ok {
+		return nil
+	}
+
+	return claims
+}
+
+// contextSetActor() returns a new copy of the request with the user who is impersonating
+// the user in the context added to it.
+func (app *application) contextSetActor(r *http.Request, actor *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), actorContextKey, actor)
+
+	return r.WithContext(ctx)
+}
+
+// contextGetActor() retrieves the user who made the request while impersonating the user
+// in the context. Requests that aren't made through impersonation carry no actor, in
+// which case nil is returned.
+func (app *application) contextGetActor(r *http.Request) *data.User {
+	actor, ok := r.Context().Value(actorContextKey).(*data.User)
+	if !ok {
+		return nil
+	}
+
+	return actor
+}
+
+// contextGetFullUser() returns the complete record of the user in the request context.
+// Users authenticated with a signed token only carry the details embedded in the token,
+// so their record is loaded from the database.
+func (app *application) contextGetFullUser(r *http.Request) (*data.User, error) {
+	user := app.contextGetUser(r)
+
+	if app.contextGetClaims(r) == nil {
+		return user, nil
+	}
+
+	return app.models.Users.Get(user.ID)
+}
@@ -81,7 +81,36 @@ func (app *application) inactiveAccountResponse(rw http.ResponseWriter, r *http.
 	app.errResponse(rw, r, http.StatusForbidden, message)
 }
 
+func (app *application) suspendedAccountResponse(rw http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errResponse(rw, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(rw http.ResponseWriter, r *http.Request) {
 	message := "your account lacks the required permissions to access this resource"
 	app.errResponse(rw, r, http.StatusForbidden, message)
 }
+
+func (app *application) registrationClosedResponse(rw http.ResponseWriter, r *http.Request) {
+	message := "registration is by invitation only"
+	app.errResponse(rw, r, http.StatusForbidden, message)
+}
+
+// The oauthErrorResponse() helper sends an error from the OAuth token, introspection and
+// revocation endpoints in the format defined by RFC 6749, which OAuth clients expect.
+func (app *application) oauthErrorResponse(rw http.ResponseWriter, r *http.Request, status int, code, description string) {
+	env := envelope{"error": code, "error_description": description}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	if status == http.StatusUnauthorized {
+		headers.Set("WWW-Authenticate", "Basic")
+	}
+
+	err := app.writeJSON(rw, status, env, headers)
+	if err != nil {
+		app.logError(r, err)
+		rw.WriteHeader(500)
+	}
+}
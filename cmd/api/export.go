@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// createDataExportHandler() starts assembling an archive of all the data held about the
+// current user. The archive is built in the background, and the user is emailed a token
+// to download it with once it's ready. The token can only be used once, within an hour.
+func (app *application) createDataExportHandler(rw http.ResponseWriter, r *http.Request) {
+	user, err := app.contextGetFullUser(r)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.background(func() {
+		archive, err := app.buildDataExport(user.ID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
+
+		token, err := app.models.DataExports.New(user.ID, archive, time.Hour)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
+
+		data := map[string]interface{}{
+			"exportToken": token.PlainText,
+		}
+
+		err = app.mailer.Send(user.Email, "user_data_export.go.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		err = app.models.DataExports.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you once your data export is ready to download"}
+
+	err = app.writeJSON(rw, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// showDataExportHandler() sends the archive of a finished data export as a ZIP file. The
+// token from the email must be presented by the user that the export belongs to. As the
+// token is sent in the query string, where it may end up in logs, it expires after an
+// hour and the export is removed as soon as it has been downloaded; the user can
+// request another export if they need to.
+func (app *application) showDataExportHandler(rw http.ResponseWriter, r *http.Request) {
+	plainTextToken := app.readString(r.URL.Query(), "token", "")
+
+	v := validator.New()
+
+	if data.ValidatePlainTextToken(v, plainTextToken); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	archive, err := app.models.DataExports.Take(user.ID, plainTextToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired token")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/zip")
+	rw.Header().Set("Content-Disposition", `attachment; filename="sunrise-data-export.zip"`)
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(archive)
+}
+
+// The buildDataExport() helper assembles a ZIP archive of everything held about a user,
+// with one JSON file for each kind of record. Token hashes and secrets are left out,
+// as the tokens are only described. The movie catalogue holds no content created by
+// users, so there is nothing to include from it.
+func (app *application) buildDataExport(userID int64) ([]byte, error) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingEmail, err := app.models.Users.GetPendingEmail(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	loginAttempts, err := app.models.LoginAttempts.GetAllForEmail(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string][]*data.TokenInfo)
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopeAPIKey, data.ScopeOAuthAccess} {
+		tokens[scope], err = app.models.Tokens.GetAllForUser(userID, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthClients, err := app.models.OAuthClients.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := app.models.Invitations.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates, err := app.models.Certificates.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor, err := app.models.TwoFactor.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"user.json", envelope{"user": user, "pending_email": pendingEmail}},
+		{"login_attempts.json", loginAttempts},
+		{"sessions.json", sessions},
+		{"authentication_tokens.json", tokens[data.ScopeAuthentication]},
+		{"refresh_tokens.json", tokens[data.ScopeRefresh]},
+		{"api_keys.json", tokens[data.ScopeAPIKey]},
+		{"oauth_access_tokens.json", tokens[data.ScopeOAuthAccess]},
+		{"permissions.json", permissions},
+		{"roles.json", roles},
+		{"identities.json", identities},
+		{"oauth_clients.json", oauthClients},
+		{"invitations.json", invitations},
+		{"certificates.json", certificates},
+		{"two_factor.json", envelope{"enabled": twoFactor}},
+	}
+
+	var buf bytes.Buffer
+
+	archive := zip.NewWriter(&buf)
+
+	for _, file := range files {
+		js, err := json.MarshalIndent(file.content, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = w.Write(append(js, '\n'))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// eraseCurrentUserHandler() erases the current user, anonymizing their account rather
+// than deleting it.
+func (app *application) eraseCurrentUserHandler(rw http.ResponseWriter, r *http.Request) {
+	app.startErasure(rw, r, app.contextGetUser(r).ID)
+}
+
+func (app *application) eraseUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	app.startErasure(rw, r, user.ID)
+}
+
+// The startErasure() helper erases a user in the background, and responds straight
+// away. The outcome of the erasure is only logged.
+func (app *application) startErasure(rw http.ResponseWriter, r *http.Request, userID int64) {
+	app.background(func() {
+		err := app.models.Users.Erase(userID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": strconv.FormatInt(userID, 10)})
+
+			return
+		}
+
+		app.syncRevocations()
+
+		app.logger.PrintInfo("user erased", map[string]string{"user_id": strconv.FormatInt(userID, 10)})
+	})
+
+	err := app.writeJSON(rw, http.StatusAccepted, envelope{"message": "the user account will be erased"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -19,16 +20,44 @@ type envelope map[string]interface{}
 
 // The readIDParam reads the params from the request and converts it into an int
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// The readNamedIDParam() helper works like readIDParam(), but reads the ID from the
+// URL parameter with the given name.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
+// The currentUserOr() helper sends requests whose id URL parameter is the literal "me"
+// to the me handler, and all other requests to the byID handler. httprouter doesn't
+// allow a static "/v1/users/me" segment to sit alongside the "/v1/users/:id" wildcard,
+// so the current-user routes are registered under the wildcard using this helper. A
+// nil handler results in a 404 Not Found response.
+func (app *application) currentUserOr(me, byID http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		next := byID
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "me" {
+			next = me
+		}
+
+		if next == nil {
+			app.notFoundResponse(rw, r)
+
+			return
+		}
+
+		next(rw, r)
+	}
+}
+
 // The readString() helper returns a string value from the query string, or the provided
 // default value if no matching key could be found.
 func (app *application) readString(qs url.Values, key, defaultValue string) string {
@@ -72,6 +101,37 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return intValue
 }
 
+// The readBool() helper reads a string value from the query string and converts it to a
+// boolean before returning it. If no matching key could be found it returns nil. If the
+// value couldn't be converted to a boolean, then we record an error message in the
+// provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	strValue := qs.Get(key)
+	if strValue == "" {
+		return nil
+	}
+
+	boolValue, err := strconv.ParseBool(strValue)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+
+		return nil
+	}
+
+	return &boolValue
+}
+
+// The clientIP() helper returns the IP address of the client that made the request,
+// without the port number.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 func (app *application) writeJSON(rw http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
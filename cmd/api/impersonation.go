@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// createImpersonationTokenHandler() issues a short-lived token which lets the current
+// user act as another user, so that support staff can see what the user sees. A user can
+// only be impersonated by someone who holds every permission that they do, so that
+// impersonation can't be used to gain permissions.
+func (app *application) createImpersonationTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	owned, err := app.models.Permissions.GetAllForUser(actor.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(user.ID != actor.ID, "user", "you can't impersonate yourself")
+	v.Check(!user.IsSuspended(), "user", "must not be suspended")
+
+	for _, code := range permissions {
+		v.Check(owned.Include(code), "user", "holds permissions that you don't")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	token, err := app.models.Tokens.NewImpersonation(user.ID, actor.ID, 15*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.logger.PrintInfo("impersonation started", map[string]string{
+		"actor_id": strconv.FormatInt(actor.ID, 10),
+		"user_id":  strconv.FormatInt(user.ID, 10),
+	})
+
+	err = app.writeJSON(rw, http.StatusCreated, envelope{"impersonation_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// The impersonationActor() helper returns the user behind an impersonation token. It
+// returns nil if the actor has since been suspended or has lost the permission to
+// impersonate, which ends the impersonation straight away.
+func (app *application) impersonationActor(actorID int64) (*data.User, error) {
+	actor, err := app.models.Users.Get(actorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if actor.IsSuspended() {
+		return nil, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(actor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !permissions.Include("users:impersonate") {
+		return nil, nil
+	}
+
+	return actor, nil
+}
+
+// logImpersonation() records every request that could change data while a user is
+// being impersonated, along with both the impersonated user and the actor behind them.
+func (app *application) logImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		actor := app.contextGetActor(r)
+
+		if actor != nil && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			app.logger.PrintInfo("impersonated request", map[string]string{
+				"actor_id":       strconv.FormatInt(actor.ID, 10),
+				"user_id":        strconv.FormatInt(app.contextGetUser(r).ID, 10),
+				"request_method": r.Method,
+				"request_url":    r.URL.String(),
+			})
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
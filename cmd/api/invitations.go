@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// createInvitationHandler() invites someone to register, emailing them a token which
+// they register with. This is how users join when open registration is closed.
+func (app *application) createInvitationHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string   `json:"email"`
+		Permissions []string `json:"permissions"`
+		Roles       []string `json:"roles"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		Permissions: input.Permissions,
+		Roles:       input.Roles,
+		InvitedBy:   app.contextGetUser(r).ID,
+	}
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = data.Permissions{}
+	}
+
+	if invitation.Roles == nil {
+		invitation.Roles = []string{}
+	}
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	known := make([]string, 0, len(roles))
+	for _, role := range roles {
+		known = append(known, role.Name)
+	}
+
+	v := validator.New()
+
+	data.ValidateInvitation(v, invitation)
+
+	for _, name := range invitation.Roles {
+		v.Check(validator.In(name, known...), "roles", "must only contain known role names")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(invitation.Email)
+	if err == nil {
+		v.AddError("email", "a user with this email already exists")
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	token, err := app.models.Invitations.New(invitation, 7*24*time.Hour)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"invitationToken": token.PlainText,
+		}
+
+		err := app.mailer.Send(invitation.Email, "user_invitation.go.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(rw, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// The registerInvitedUser() helper registers a user with an invitation token. The user
+// is activated straight away, and granted the permissions and roles of the invitation.
+// The email address may be left out, in which case the invited address is used.
+func (app *application) registerInvitedUser(rw http.ResponseWriter, r *http.Request, name, email, password, invitationToken string) {
+	v := validator.New()
+
+	if v.Check(len(invitationToken) == 26, "invitation_token", "must be 26 bytes long"); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	invitation, err := app.models.Invitations.GetForToken(invitationToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired token")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	if email == "" {
+		email = invitation.Email
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Activated: true,
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	data.ValidateUser(v, user)
+
+	v.Check(strings.EqualFold(user.Email, invitation.Email), "email", "must match the email address that was invited")
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	// The invitation is used up in the same transaction that creates the user, so it
+	// can only be used once, and a failure part of the way through leaves it usable.
+	err = app.models.Invitations.Accept(invitation, invitationToken, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired token")
+			app.failedValidationResponse(rw, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+)
+
+// The loginLocked() helper reports whether logins for an email address from the client
+// are currently refused, based on the failed attempts made within the lockout window.
+func (app *application) loginLocked(r *http.Request, email string) (bool, error) {
+	now := time.Now()
+
+	attempts, err := app.models.LoginAttempts.GetRecent(email, app.clientIP(r), now.Add(-app.config.lockout.window))
+	if err != nil {
+		return false, err
+	}
+
+	return app.lockedOut(attempts, now), nil
+}
+
+// The lockedOut() helper reports whether the failed login attempts lock a client out at
+// the given time. After each failed attempt for an email address the client must wait
+// twice as long as before, and once the threshold is reached the address is locked for
+// the rest of the lockout window. Clients that fail too often across any number of
+// addresses are locked out in the same way.
+func (app *application) lockedOut(attempts *data.LoginAttempts, now time.Time) bool {
+	if attempts.IPFailures >= app.config.lockout.ipThreshold {
+		return now.Before(attempts.LastIPFailure.Add(app.config.lockout.window))
+	}
+
+	switch {
+	case attempts.EmailFailures == 0:
+		return false
+	case attempts.EmailFailures >= app.config.lockout.threshold:
+		return now.Before(attempts.LastEmailFailure.Add(app.config.lockout.window))
+	default:
+		backOff := time.Second
+
+		for i := 1; i < attempts.EmailFailures && backOff < app.config.lockout.window; i++ {
+			backOff *= 2
+		}
+
+		return now.Before(attempts.LastEmailFailure.Add(backOff))
+	}
+}
+
+// The recordFailedLogin() helper records a failed login attempt for an email address.
+// Attempts are recorded whether or not an account exists for the address, so that the
+// lockout behaves the same either way. The owner of the account, if there is one, is
+// notified when the attempt causes it to be locked.
+func (app *application) recordFailedLogin(r *http.Request, email string, user *data.User) error {
+	now := time.Now()
+	clientIP := app.clientIP(r)
+
+	failures, err := app.models.LoginAttempts.Insert(email, clientIP, now.Add(-app.config.lockout.window))
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		err := app.models.LoginAttempts.DeleteExpired(now.Add(-app.config.lockout.window))
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	if failures != app.config.lockout.threshold {
+		return nil
+	}
+
+	app.logger.PrintInfo("login locked after repeated failures", map[string]string{
+		"client_ip": clientIP,
+	})
+
+	if user != nil {
+		app.background(func() {
+			data := map[string]interface{}{
+				"clientIP":       clientIP,
+				"lockoutMinutes": int(app.config.lockout.window.Minutes()),
+			}
+
+			err := app.mailer.Send(user.Email, "user_account_locked.go.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	return nil
+}
+
+// The failedLoginResponse() helper records a failed login attempt and sends the client
+// an invalid credentials response.
+func (app *application) failedLoginResponse(rw http.ResponseWriter, r *http.Request, email string, user *data.User) {
+	err := app.recordFailedLogin(r, email, user)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.invalidCredentialsResponse(rw, r)
+}
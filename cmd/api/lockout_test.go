@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+)
+
+func TestLockedOut(t *testing.T) {
+	app := &application{}
+	app.config.lockout.threshold = 5
+	app.config.lockout.window = 15 * time.Minute
+	app.config.lockout.ipThreshold = 50
+
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		failures   int
+		ipFailures int
+		elapsed    time.Duration
+		want       bool
+	}{
+		{"no failures", 0, 0, 0, false},
+		{"first failure waits a second", 1, 1, 500 * time.Millisecond, true},
+		{"first failure after a second", 1, 1, time.Second, false},
+		{"second failure waits two seconds", 2, 2, 1500 * time.Millisecond, true},
+		{"second failure after two seconds", 2, 2, 2 * time.Second, false},
+		{"fourth failure waits eight seconds", 4, 4, 7 * time.Second, true},
+		{"fourth failure after eight seconds", 4, 4, 8 * time.Second, false},
+		{"threshold locks for the window", 5, 5, 14 * time.Minute, true},
+		{"threshold lock ends with the window", 5, 5, 15 * time.Minute, false},
+		{"beyond the threshold", 9, 9, time.Minute, true},
+		{"client threshold locks for the window", 0, 50, 14 * time.Minute, true},
+		{"client threshold lock ends with the window", 0, 50, 15 * time.Minute, false},
+		{"below client threshold", 0, 49, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := &data.LoginAttempts{
+				EmailFailures:    tt.failures,
+				LastEmailFailure: last,
+				IPFailures:       tt.ipFailures,
+				LastIPFailure:    last,
+			}
+
+			if got := app.lockedOut(attempts, last.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockedOutBackOffIsCappedByWindow(t *testing.T) {
+	app := &application{}
+	app.config.lockout.threshold = 100
+	app.config.lockout.window = time.Minute
+	app.config.lockout.ipThreshold = 1000
+
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	attempts := &data.LoginAttempts{EmailFailures: 60, LastEmailFailure: last}
+
+	// Without the cap, the back-off would have overflowed long before 60 failures.
+	if !app.lockedOut(attempts, last.Add(30*time.Second)) {
+		t.Error("expected to be locked out within the back-off")
+	}
+
+	if app.lockedOut(attempts, last.Add(2*time.Minute)) {
+		t.Error("expected the back-off to end")
+	}
+}
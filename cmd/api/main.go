@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
+	"net/http"
 	"os"
 	"runtime"
 	"strings"
@@ -13,7 +15,9 @@ import (
 
 	"github.com/mycok/sunrise-api/internal/data"
 	"github.com/mycok/sunrise-api/internal/jsonlog"
+	"github.com/mycok/sunrise-api/internal/jwt"
 	"github.com/mycok/sunrise-api/internal/mailer"
+	"github.com/mycok/sunrise-api/internal/oidc"
 
 	_ "github.com/lib/pq"
 )
@@ -45,15 +49,61 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	tokens struct {
+		accessTTL        time.Duration
+		refreshTTL       time.Duration
+		mode             string
+		keysDir          string
+		signingKeyID     string
+		revocationCutoff time.Time
+		revocationSync   time.Duration
+	}
+	argon2 struct {
+		memory      uint
+		iterations  uint
+		parallelism uint
+	}
+	passwords struct {
+		minEntropy   float64
+		breachedFile string
+		legacyTiming bool
+	}
+	registration struct {
+		enumerationSafe bool
+		closed          bool
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+		defaultRole  string
+	}
+	oauth struct {
+		accessTTL time.Duration
+	}
+	tls struct {
+		certFile     string
+		keyFile      string
+		clientCAFile string
+	}
+	lockout struct {
+		threshold   int
+		window      time.Duration
+		ipThreshold int
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers, and middleware.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	models      data.Models
+	mailer      mailer.Mailer
+	signingKeys *jwt.KeySet
+	revocations *revocationCache
+	oidc        *oidc.Provider
+	wg          sync.WaitGroup
 }
 
 func openDB(cfg config) (*sql.DB, error) {
@@ -109,6 +159,54 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "967bce52ce4e76", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "<no-reply@sunrise-tech.co.ug>", "SMTP sender")
 
+	flag.DurationVar(&cfg.tokens.accessTTL, "token-access-ttl", 15*time.Minute, "Authentication token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.StringVar(&cfg.tokens.mode, "token-mode", "opaque", "Authentication token mode (opaque|signed)")
+	flag.StringVar(&cfg.tokens.keysDir, "token-keys-dir", "", "Directory holding the keys for signed tokens")
+	flag.StringVar(&cfg.tokens.signingKeyID, "token-signing-key", "", "ID of the key used to sign new tokens")
+	flag.DurationVar(&cfg.tokens.revocationSync, "token-revocation-sync", 10*time.Second, "How often the denylist of revoked signed tokens is reloaded from the database")
+
+	flag.UintVar(&cfg.argon2.memory, "argon2-memory", uint(data.DefaultArgon2idHasher.Memory), "Argon2id password hashing memory in KiB")
+	flag.UintVar(&cfg.argon2.iterations, "argon2-iterations", uint(data.DefaultArgon2idHasher.Iterations), "Argon2id password hashing iterations")
+	flag.UintVar(&cfg.argon2.parallelism, "argon2-parallelism", uint(data.DefaultArgon2idHasher.Parallelism), "Argon2id password hashing parallelism")
+
+	flag.Float64Var(&cfg.passwords.minEntropy, "password-min-entropy", data.PasswordPolicy.MinEntropy, "Minimum estimated strength of new passwords in bits")
+	flag.StringVar(&cfg.passwords.breachedFile, "password-breached-file", "", "File of SHA-1 hashes of breached passwords to reject")
+	flag.BoolVar(&cfg.passwords.legacyTiming, "password-legacy-timing", false, "Time logins for unknown emails like logins for accounts with legacy bcrypt hashes, while most accounts have one")
+
+	flag.BoolVar(&cfg.registration.enumerationSafe, "registration-enumeration-safe", false, "Respond to every registration the same way, emailing the owner of an existing account instead")
+	flag.BoolVar(&cfg.registration.closed, "registration-closed", false, "Only allow users to register with an invitation")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL (login with the provider is disabled when empty)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "OpenID Connect redirect URL registered with the provider")
+	flag.StringVar(&cfg.oidc.defaultRole, "oidc-default-role", "viewer", "Role given to users provisioned through OpenID Connect")
+
+	flag.DurationVar(&cfg.oauth.accessTTL, "oauth-access-ttl", time.Hour, "Lifetime of access tokens issued to OAuth clients")
+
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file (the server uses plain HTTP when empty)")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.StringVar(&cfg.tls.clientCAFile, "tls-client-ca", "", "CA bundle used to verify client certificates (client certificates are ignored when empty)")
+
+	flag.IntVar(&cfg.lockout.threshold, "lockout-threshold", 5, "Failed logins for an email address before it is locked")
+	flag.DurationVar(&cfg.lockout.window, "lockout-window", 15*time.Minute, "Period that failed logins are counted over and locks last for")
+	flag.IntVar(&cfg.lockout.ipThreshold, "lockout-ip-threshold", 50, "Failed logins from an IP address before it is locked")
+
+	// Only signed tokens issued at or after the revocation cutoff are checked against the
+	// revocation denylist; older ones are trusted until they expire. When it isn't set,
+	// every signed token is checked.
+	flag.Func("token-revocation-cutoff", "Check signed tokens issued from this RFC3339 time against the revocation denylist", func(value string) error {
+		cutoff, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+
+		cfg.tokens.revocationCutoff = cutoff
+
+		return nil
+	})
+
 	// Use the flag.Func() function to process the -cors-trusted-origins command line
 	// flag. In this we use the strings.Fields() function to split the flag value into a
 	// slice based on whitespace characters and assign it to our config struct.
@@ -134,6 +232,71 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	// New passwords are hashed with argon2id. Existing hashes made with other parameters
+	// or algorithms are upgraded as their owners log in.
+	data.PasswordHasher = data.Argon2idHasher{
+		Memory:      uint32(cfg.argon2.memory),
+		Iterations:  uint32(cfg.argon2.iterations),
+		Parallelism: uint8(cfg.argon2.parallelism),
+		SaltLength:  data.DefaultArgon2idHasher.SaltLength,
+		KeyLength:   data.DefaultArgon2idHasher.KeyLength,
+	}
+
+	// Logins for unknown email addresses are timed to match the hashes that most
+	// accounts have, which may still be legacy bcrypt hashes early in the migration.
+	if cfg.passwords.legacyTiming {
+		data.DummyPasswordHasher = data.LegacyBcryptHasher
+	}
+
+	data.PasswordPolicy.MinEntropy = cfg.passwords.minEntropy
+
+	if cfg.passwords.breachedFile != "" {
+		data.PasswordPolicy.Breached, err = data.LoadBreachedPasswords(cfg.passwords.breachedFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("breached passwords list loaded", nil)
+	}
+
+	// Load the signing keys when authentication tokens are issued as signed tokens. The
+	// revocation denylist that signed tokens are checked against is then held in memory.
+	var signingKeys *jwt.KeySet
+	var revocations *revocationCache
+
+	switch cfg.tokens.mode {
+	case "opaque":
+	case "signed":
+		signingKeys, err = jwt.LoadKeySet(cfg.tokens.keysDir, cfg.tokens.signingKeyID)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		revocations = newRevocationCache()
+	default:
+		logger.PrintFatal(errors.New("invalid token mode: "+cfg.tokens.mode), nil)
+	}
+
+	// Discover the endpoints of the OpenID provider when logging in with it is enabled.
+	var oidcProvider *oidc.Provider
+
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		oidcProvider, err = oidc.Discover(ctx, &http.Client{Timeout: 10 * time.Second}, oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		})
+
+		cancel()
+
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
 	// Add custom fields to expvar debug JSON output
 	expvar.NewString("version").Set(version)
 	expvar.Publish("database", expvar.Func(func() interface{} {
@@ -150,10 +313,22 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.New(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:      cfg,
+		logger:      logger,
+		models:      data.New(db),
+		mailer:      mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		signingKeys: signingKeys,
+		revocations: revocations,
+		oidc:        oidcProvider,
+	}
+
+	if app.revocations != nil {
+		err = app.loadRevocations()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		go app.reloadRevocations(cfg.tokens.revocationSync)
 	}
 
 	err = app.serve()
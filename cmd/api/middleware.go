@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/jwt"
 	"github.com/mycok/sunrise-api/internal/validator"
 	"golang.org/x/time/rate"
 )
@@ -125,6 +126,14 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// return the empty string "" if there is no such header found.
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
+			// A request with a client certificate that was verified during the TLS
+			// handshake is authenticated as the user the certificate is mapped to.
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				app.authenticateCertificate(rw, r, next)
+
+				return
+			}
+
 			r = app.contextSetUser(r, data.AnonymousUser)
 
 			next.ServeHTTP(rw, r)
@@ -133,11 +142,36 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Otherwise, we expect the value of the Authorization header to be in the format
-		// "Bearer <token>". We try to split this into its constituent parts, and if the
-		// header isn't in the expected format we return a 401 Unauthorized response
-		// using the invalidAuthenticationTokenResponse() helper.
+		// "Bearer <token>" or "ApiKey <key>". We try to split this into its constituent
+		// parts, and if the header isn't in the expected format we return a 401
+		// Unauthorized response using the invalidAuthenticationTokenResponse() helper.
 		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 {
+			app.invalidAuthTokenResponse(rw, r)
+
+			return
+		}
+
+		// The authentication scheme determines which scopes of token we look for. Bearer
+		// tokens are either our own authentication and impersonation tokens, or access
+		// tokens issued to OAuth clients.
+		var scopes []string
+
+		switch headerParts[0] {
+		case "Bearer":
+			scopes = []string{data.ScopeAuthentication, data.ScopeImpersonation, data.ScopeOAuthAccess}
+		case "ApiKey":
+			scopes = []string{data.ScopeAPIKey}
+		case "Basic":
+			// OAuth clients authenticate themselves with Basic auth, which the OAuth
+			// endpoints check. The request is treated as anonymous as far as users are
+			// concerned.
+			r = app.contextSetUser(r, data.AnonymousUser)
+
+			next.ServeHTTP(rw, r)
+
+			return
+		default:
 			app.invalidAuthTokenResponse(rw, r)
 
 			return
@@ -145,6 +179,40 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		// In signed mode, signed bearer tokens are verified locally without looking the
+		// user up in the database. Opaque tokens are still accepted below, so that
+		// sessions issued before switching modes keep working.
+		if headerParts[0] == "Bearer" && app.signingKeys != nil && strings.Count(token, ".") == 2 {
+			claims, err := app.verifySignedToken(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, jwt.ErrInvalidToken), errors.Is(err, jwt.ErrExpiredToken), errors.Is(err, jwt.ErrUnknownKey):
+					app.invalidAuthTokenResponse(rw, r)
+				default:
+					app.serverErrorResponse(rw, r, err)
+				}
+
+				return
+			}
+
+			user := &data.User{
+				ID:        claims.userID(),
+				Activated: claims.Activated,
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, &data.Token{
+				UserID: user.ID,
+				Scope:  data.ScopeAuthentication,
+				Family: claims.Family,
+			})
+			r = app.contextSetClaims(r, claims)
+
+			next.ServeHTTP(rw, r)
+
+			return
+		}
+
 		v := validator.New()
 		if data.ValidatePlainTextToken(v, token); !v.Valid() {
 			app.invalidAuthTokenResponse(rw, r)
@@ -155,7 +223,36 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Retrieve the details of the user associated with the authentication token,
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found.
-		user, err := app.models.Users.GetForToken(token, data.ScopeAuthentication)
+		user, err := app.models.Users.GetForToken(token, scopes...)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthTokenResponse(rw, r)
+			default:
+				app.serverErrorResponse(rw, r, err)
+			}
+
+			return
+		}
+
+		// The tokens of a user are revoked when they are suspended, but check anyway so
+		// that a token issued while the suspension was being made can't be used.
+		if user.IsSuspended() {
+			app.suspendedAccountResponse(rw, r)
+
+			return
+		}
+
+		// Record the use of the token against its session, capturing the device that
+		// is currently using it. This also reads back the scope of the token.
+		session := &data.Token{
+			Hash:      data.HashPlainTextToken(token),
+			UserID:    user.ID,
+			UserAgent: r.UserAgent(),
+			ClientIP:  app.clientIP(r),
+		}
+
+		err = app.models.Tokens.Touch(session)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -167,7 +264,26 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Impersonation tokens also carry the user who is acting as the token's owner.
+		if session.Scope == data.ScopeImpersonation {
+			actor, err := app.impersonationActor(session.ActorID)
+			if err != nil {
+				app.serverErrorResponse(rw, r, err)
+
+				return
+			}
+
+			if actor == nil {
+				app.invalidAuthTokenResponse(rw, r)
+
+				return
+			}
+
+			r = app.contextSetActor(r, actor)
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, session)
 
 		next.ServeHTTP(rw, r)
 	})
@@ -204,16 +320,44 @@ func (app *application) requiresActivatedUser(next http.HandlerFunc) http.Handle
 	return app.requiresAuthentication(fn)
 }
 
+// requiresUserSession() checks that the request was authenticated by the user in a
+// session of their own, rather than with an API key, an OAuth access token, a client
+// certificate or through impersonation. Delegated tokens only grant access to the
+// resources guarded by their permissions, certificates identify services rather than
+// people, and impersonators are only there to see what the user sees, so none of them
+// can be used to manage the account itself, or to mint further tokens.
+func (app *application) requiresUserSession(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token := app.contextGetToken(r)
+		if token != nil && (token.IsDelegated() || token.Scope == data.ScopeImpersonation || token.Scope == data.ScopeCertificate) {
+			app.notPermittedResponse(rw, r)
+
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
 func (app *application) requiresPermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(rw http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(rw, r, err)
+		// Get the slice of permissions for the user. Signed tokens carry the
+		// permissions of the user at the time they were issued.
+		var permissions data.Permissions
 
-			return
+		if claims := app.contextGetClaims(r); claims != nil {
+			permissions = claims.Permissions
+		} else {
+			var err error
+
+			permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(rw, r, err)
+
+				return
+			}
 		}
 
 		// Check if the slice includes the required permission. If it doesn't, then
@@ -224,6 +368,24 @@ func (app *application) requiresPermission(code string, next http.HandlerFunc) h
 			return
 		}
 
+		// An impersonator can never impersonate someone else through the user they are
+		// already impersonating.
+		if code == "users:impersonate" && app.contextGetActor(r) != nil {
+			app.notPermittedResponse(rw, r)
+
+			return
+		}
+
+		// Requests made with an API key or an OAuth access token are further limited to
+		// the permissions that were delegated to the token, so the token only grants
+		// what both it and its owner currently hold.
+		token := app.contextGetToken(r)
+		if token != nil && token.IsDelegated() && !token.Permissions.Include(code) {
+			app.notPermittedResponse(rw, r)
+
+			return
+		}
+
 		next.ServeHTTP(rw, r)
 	}
 
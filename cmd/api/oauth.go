@@ -0,0 +1,508 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+func (app *application) createOAuthClientHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	client := &data.OAuthClient{
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+		UserID:       user.ID,
+	}
+
+	v := validator.New()
+	if data.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.OAuthClients.New(client, input.Confidential)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// The plaintext client secret is only ever included in this response.
+	err = app.writeJSON(rw, http.StatusCreated, envelope{"oauth_client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) listOAuthClientsHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	clients, err := app.models.OAuthClients.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"oauth_clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) deleteOAuthClientHandler(rw http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDParam(r, "oauth_client_id")
+	if err != nil {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.OAuthClients.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "oauth client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// oauthAuthorization type holds the parameters of an authorization request, which a
+// client makes by sending the user to our consent screen.
+type oauthAuthorization struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// The validateAuthorization() helper checks an authorization request on behalf of the
+// current user, and returns the client making it along with the permissions it asks
+// for. Every client must use PKCE, and may only ask for permissions that it was
+// registered with and that the user holds.
+func (app *application) validateAuthorization(v *validator.Validator, r *http.Request, auth *oauthAuthorization) (*data.OAuthClient, data.Permissions, error) {
+	v.Check(auth.ResponseType == "code", "response_type", `must be "code"`)
+	v.Check(auth.CodeChallengeMethod == "S256", "code_challenge_method", `must be "S256"`)
+	v.Check(len(auth.CodeChallenge) >= 43 && len(auth.CodeChallenge) <= 128, "code_challenge", "must be between 43 and 128 bytes long")
+	v.Check(len(auth.State) <= 500, "state", "must not be more than 500 bytes")
+
+	client, err := app.models.OAuthClients.GetByClientID(auth.ClientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("client_id", "unknown client")
+
+			return nil, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	// The redirect URI may only be left out when the client has just one.
+	if auth.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		auth.RedirectURI = client.RedirectURIs[0]
+	}
+
+	v.Check(client.AllowsRedirectURI(auth.RedirectURI), "redirect_uri", "must be registered for the client")
+
+	owned, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	permissions := data.Permissions(strings.Fields(auth.Scope))
+
+	v.Check(len(permissions) >= 1, "scope", "must be provided")
+	v.Check(validator.Unique(permissions), "scope", "must not contain duplicate values")
+
+	for _, code := range permissions {
+		v.Check(client.Scopes.Include(code), "scope", fmt.Sprintf("%q is not a scope the client may request", code))
+		v.Check(owned.Include(code), "scope", fmt.Sprintf("%q is not a permission you hold", code))
+	}
+
+	return client, permissions, nil
+}
+
+// showOAuthAuthorizationHandler() checks an authorization request, which is passed in
+// the query string, and returns the details that the user is asked to consent to.
+func (app *application) showOAuthAuthorizationHandler(rw http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	auth := &oauthAuthorization{
+		ResponseType:        app.readString(qs, "response_type", ""),
+		ClientID:            app.readString(qs, "client_id", ""),
+		RedirectURI:         app.readString(qs, "redirect_uri", ""),
+		Scope:               app.readString(qs, "scope", ""),
+		State:               app.readString(qs, "state", ""),
+		CodeChallenge:       app.readString(qs, "code_challenge", ""),
+		CodeChallengeMethod: app.readString(qs, "code_challenge_method", ""),
+	}
+
+	v := validator.New()
+
+	client, permissions, err := app.validateAuthorization(v, r, auth)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	env := envelope{
+		"client":       envelope{"client_id": client.ClientID, "name": client.Name},
+		"scopes":       permissions,
+		"redirect_uri": auth.RedirectURI,
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// createOAuthAuthorizationHandler() records the decision of the user on an
+// authorization request, and returns the URI that they should be redirected back to
+// the client with. When the user approves, it carries a short-lived authorization code.
+func (app *application) createOAuthAuthorizationHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		oauthAuthorization
+		Approved bool `json:"approved"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	auth := &input.oauthAuthorization
+
+	v := validator.New()
+
+	client, permissions, err := app.validateAuthorization(v, r, auth)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	params := url.Values{}
+
+	if auth.State != "" {
+		params.Set("state", auth.State)
+	}
+
+	if input.Approved {
+		code := &data.OAuthCode{
+			ClientID:      client.ID,
+			UserID:        app.contextGetUser(r).ID,
+			RedirectURI:   auth.RedirectURI,
+			CodeChallenge: auth.CodeChallenge,
+			Permissions:   permissions,
+		}
+
+		err = app.models.OAuthCodes.New(code, 10*time.Minute)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		params.Set("code", code.PlainText)
+	} else {
+		params.Set("error", "access_denied")
+	}
+
+	separator := "?"
+	if strings.Contains(auth.RedirectURI, "?") {
+		separator = "&"
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"redirect_uri": auth.RedirectURI + separator + params.Encode()}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// The authenticateOAuthClient() helper identifies the client calling the token,
+// introspection or revocation endpoints. Clients authenticate with HTTP Basic auth or
+// with form parameters, and confidential clients must present their secret. It returns
+// nil if the client couldn't be authenticated.
+func (app *application) authenticateOAuthClient(r *http.Request) (*data.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.models.OAuthClients.GetByClientID(clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if client.IsConfidential() != (secret != "") {
+		return nil, nil
+	}
+
+	if client.IsConfidential() && !client.SecretMatches(secret) {
+		return nil, nil
+	}
+
+	return client, nil
+}
+
+// createOAuthTokenHandler() implements the token endpoint of RFC 6749, issuing access
+// tokens for the authorization code and client credentials grants. Access tokens act
+// for the user with only the permissions that were granted to the client.
+func (app *application) createOAuthTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(rw, r, http.StatusBadRequest, "invalid_request", "the request body could not be parsed")
+
+		return
+	}
+
+	client, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if client == nil {
+		app.oauthErrorResponse(rw, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+
+		return
+	}
+
+	var userID int64
+	var permissions data.Permissions
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := app.models.OAuthCodes.Take(r.PostForm.Get("code"))
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		// The code must have been issued to this client for the same redirect URI, and
+		// the code verifier must match the challenge that was sent with the request.
+		if code == nil ||
+			code.ClientID != client.ID ||
+			code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+			!verifyCodeChallenge(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			app.oauthErrorResponse(rw, r, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or has expired")
+
+			return
+		}
+
+		userID = code.UserID
+		permissions = code.Permissions
+	case "client_credentials":
+		// Only confidential clients can act on their own, on behalf of the user who
+		// registered them.
+		if !client.IsConfidential() {
+			app.oauthErrorResponse(rw, r, http.StatusBadRequest, "unauthorized_client", "public clients may not use the client credentials grant")
+
+			return
+		}
+
+		permissions = data.Permissions(strings.Fields(r.PostForm.Get("scope")))
+		if len(permissions) == 0 {
+			permissions = client.Scopes
+		}
+
+		for _, code := range permissions {
+			if !client.Scopes.Include(code) {
+				app.oauthErrorResponse(rw, r, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("%q is not a scope the client may request", code))
+
+				return
+			}
+		}
+
+		userID = client.UserID
+	default:
+		app.oauthErrorResponse(rw, r, http.StatusBadRequest, "unsupported_grant_type", "the grant type is not supported")
+
+		return
+	}
+
+	token, err := app.models.Tokens.NewOAuthAccess(userID, client.ID, app.config.oauth.accessTTL, permissions)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"access_token": token.PlainText,
+		"token_type":   "Bearer",
+		"expires_in":   int(app.config.oauth.accessTTL.Seconds()),
+		"scope":        strings.Join(permissions, " "),
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeJSON(rw, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// verifyCodeChallenge() reports whether a PKCE code verifier matches the S256 challenge
+// that was sent with the authorization request, as described in RFC 7636.
+func verifyCodeChallenge(codeVerifier, codeChallenge string) bool {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	encoded := base64.RawURLEncoding.EncodeToString(challenge[:])
+
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(codeChallenge)) == 1
+}
+
+// introspectOAuthTokenHandler() implements token introspection as defined by RFC 7662.
+// Clients can only introspect the tokens that were issued to them, and any other token
+// is reported as inactive.
+func (app *application) introspectOAuthTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	client, ok := app.readOAuthClientForm(rw, r)
+	if !ok {
+		return
+	}
+
+	token, err := app.models.Tokens.GetOAuthAccess(data.HashPlainTextToken(r.PostForm.Get("token")))
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	env := envelope{"active": false}
+
+	if token != nil && token.ClientID == client.ID {
+		env = envelope{
+			"active":     true,
+			"scope":      strings.Join(token.Permissions, " "),
+			"client_id":  client.ClientID,
+			"token_type": "Bearer",
+			"sub":        strconv.FormatInt(token.UserID, 10),
+			"iat":        token.CreatedAt.Unix(),
+			"exp":        token.Expiry.Unix(),
+		}
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// revokeOAuthTokenHandler() implements token revocation as defined by RFC 7009. The
+// response is the same whether or not the token was found, as the client has nothing
+// to do differently either way.
+func (app *application) revokeOAuthTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	client, ok := app.readOAuthClientForm(rw, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteOAuthAccess(data.HashPlainTextToken(r.PostForm.Get("token")), client.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// The readOAuthClientForm() helper parses the form sent to the introspection and
+// revocation endpoints and authenticates the confidential client that sent it. If that
+// fails, an error response is sent and false is returned.
+func (app *application) readOAuthClientForm(rw http.ResponseWriter, r *http.Request) (*data.OAuthClient, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(rw, r, http.StatusBadRequest, "invalid_request", "the request body could not be parsed")
+
+		return nil, false
+	}
+
+	client, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return nil, false
+	}
+
+	if client == nil || !client.IsConfidential() {
+		app.oauthErrorResponse(rw, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+
+		return nil, false
+	}
+
+	return client, true
+}
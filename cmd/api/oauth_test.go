@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// The challenge is BASE64URL(SHA256(verifier)), computed independently.
+	const (
+		verifier  = "sunrise-pkce-test-verifier-0123456789abcdef"
+		challenge = "XnWy7_fEuIdp8dcrAWKLoYM4YDIxo8oAEcCfJstZP0c"
+	)
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{"matching verifier", verifier, challenge, true},
+		{"different verifier", verifier + "x", challenge, false},
+		{"missing verifier", "", challenge, false},
+		{"plain method", verifier, verifier, false},
+		{"padded challenge", verifier, challenge + "=", false},
+		{"missing challenge", verifier, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
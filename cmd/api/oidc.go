@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/oidc"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// errUnverifiedIdentity is returned when the identity provider doesn't vouch for the
+// email address of a user who isn't linked to an account yet.
+var errUnverifiedIdentity = errors.New("identity has no verified email address")
+
+// errUnactivatedAccount is returned when an identity that isn't linked yet has the email
+// address of a user who hasn't activated their account. Anyone can register an account
+// for an email address they don't own, so linking it would hand that account, and any
+// password set on it, to the owner of the identity.
+var errUnactivatedAccount = errors.New("account for the identity's email address isn't activated")
+
+// createOIDCLoginHandler() starts a login with the external identity provider, and
+// returns the URL of the provider that the user should be sent to. The provider
+// redirects the user back to the configured redirect URL, with the code and state that
+// complete the login.
+func (app *application) createOIDCLoginHandler(rw http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	var login data.OIDCLogin
+	var err error
+
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		*value, err = oidc.NewRandom()
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+	}
+
+	err = app.models.OIDCLogins.Insert(&login, 10*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	env := envelope{"authorization_url": app.oidc.AuthCodeURL(login.State, login.Nonce, login.CodeVerifier)}
+
+	err = app.writeJSON(rw, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// oidcCallbackHandler() completes a login with the external identity provider. The code
+// is exchanged for an ID token, and the user it identifies is logged in, after first
+// being provisioned if they are new.
+func (app *application) oidcCallbackHandler(rw http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// The provider redirects back with an error instead of a code when the user
+	// couldn't be logged in, or declined to log in.
+	if qs.Get("error") != "" {
+		app.invalidCredentialsResponse(rw, r)
+
+		return
+	}
+
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+
+	v := validator.New()
+
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	login, err := app.models.OIDCLogins.Take(state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchangeFailed), errors.Is(err, oidc.ErrInvalidIDToken):
+			app.invalidCredentialsResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	user, err := app.userForIdentity(claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedIdentity):
+			app.invalidCredentialsResponse(rw, r)
+		case errors.Is(err, errUnactivatedAccount):
+			app.inactiveAccountResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	app.completeLogin(rw, r, user)
+}
+
+// The userForIdentity() helper returns the user that an external identity is linked to.
+// An identity that isn't linked yet is linked to the user with the same email address,
+// and if there isn't one a new activated user is provisioned with the default role. The
+// email address is only trusted when the provider has verified it, and an identity is
+// never linked to a user who hasn't activated their account.
+func (app *application) userForIdentity(claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(app.oidc.Issuer(), claims.Subject)
+	if err == nil || !errors.Is(err, data.ErrRecordNotFound) {
+		return user, err
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, claims.Email); !v.Valid() || !claims.EmailVerified {
+		return nil, errUnverifiedIdentity
+	}
+
+	identity := &data.Identity{
+		Issuer:  app.oidc.Issuer(),
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		return app.provisionUser(claims, identity)
+	}
+
+	if !user.Activated {
+		return nil, errUnactivatedAccount
+	}
+
+	identity.UserID = user.ID
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// The provisionUser() helper creates an activated user for a new external identity,
+// with the default role, and links the identity to them. The user is given a random
+// password, which they can replace through a password reset if they ever need to log in
+// without the provider.
+func (app *application) provisionUser(claims *oidc.Claims, identity *data.Identity) (*data.User, error) {
+	name := claims.Name
+	if name == "" || len(name) > 500 {
+		name = claims.Email
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	password, err := oidc.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+
+	if app.config.oidc.defaultRole != "" {
+		roles = append(roles, app.config.oidc.defaultRole)
+	}
+
+	err = app.models.Identities.Provision(user, identity, roles...)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
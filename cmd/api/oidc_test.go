@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/data/datatest"
+	"github.com/mycok/sunrise-api/internal/jsonlog"
+	"github.com/mycok/sunrise-api/internal/oidc"
+	"github.com/mycok/sunrise-api/internal/oidc/oidctest"
+)
+
+// newOIDCTestApplication() returns an application backed by a test database, which logs
+// users in with a fake identity provider.
+func newOIDCTestApplication(t *testing.T) (*application, *oidctest.Provider) {
+	t.Helper()
+
+	db := datatest.Open(t)
+
+	idp, err := oidctest.NewProvider("sunrise-api", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.Discover(context.Background(), idp.Server.Client(), oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  "http://localhost:4000/v1/tokens/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger: jsonlog.New(ioutil.Discard, jsonlog.LevelOff),
+		models: data.New(db),
+		oidc:   provider,
+	}
+
+	app.config.tokens.accessTTL = 15 * time.Minute
+	app.config.tokens.refreshTTL = 24 * time.Hour
+	app.config.oidc.defaultRole = "viewer"
+
+	return app, idp
+}
+
+// oidcLogin() starts a login, has the fake provider authorize it with the given claims,
+// and returns the response to the callback.
+func oidcLogin(t *testing.T, app *application, idp *oidctest.Provider, claims map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	app.createOIDCLoginHandler(rr, httptest.NewRequest(http.MethodPost, "/v1/tokens/oidc", nil))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d starting the login, want %d", rr.Code, http.StatusCreated)
+	}
+
+	var login struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+
+	err := json.NewDecoder(rr.Body).Decode(&login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizationURL, err := url.Parse(login.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := idp.Authorize(login.AuthorizationURL, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qs := url.Values{
+		"code":  {code},
+		"state": {authorizationURL.Query().Get("state")},
+	}
+
+	rr = httptest.NewRecorder()
+	app.oidcCallbackHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/tokens/oidc/callback?"+qs.Encode(), nil))
+
+	return rr
+}
+
+func TestOIDCCallbackProvisionsUser(t *testing.T) {
+	app, idp := newOIDCTestApplication(t)
+
+	rr := oidcLogin(t, app, idp, map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	})
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	user, err := app.models.Identities.GetUser(idp.Issuer(), "subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Email != "alice@example.com" || user.Name != "Alice" || !user.Activated {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(roles) != 1 || roles[0].Name != "viewer" {
+		t.Errorf("got roles %+v, want the viewer role", roles)
+	}
+
+	// Logging in again uses the linked identity, rather than provisioning another user.
+	rr = oidcLogin(t, app, idp, map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	})
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	again, err := app.models.Identities.GetUser(idp.Issuer(), "subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != user.ID {
+		t.Errorf("got user %d, want %d", again.ID, user.ID)
+	}
+}
+
+func TestOIDCCallbackLinksVerifiedEmail(t *testing.T) {
+	app, idp := newOIDCTestApplication(t)
+
+	user := &data.User{
+		Name:      "Bob",
+		Email:     "bob@example.com",
+		Activated: true,
+	}
+
+	err := user.Password.Set("pa55word-for-bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := oidcLogin(t, app, idp, map[string]interface{}{
+		"sub":            "subject-2",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	})
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	linked, err := app.models.Identities.GetUser(idp.Issuer(), "subject-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linked.ID != user.ID {
+		t.Errorf("identity linked to user %d, want %d", linked.ID, user.ID)
+	}
+
+	// Existing users keep their roles; linking an identity doesn't grant the default one.
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(roles) != 0 {
+		t.Errorf("got roles %+v, want none", roles)
+	}
+}
+
+func TestOIDCCallbackRejectsUnactivatedAccount(t *testing.T) {
+	app, idp := newOIDCTestApplication(t)
+
+	user := &data.User{
+		Name:      "Erin",
+		Email:     "erin@example.com",
+		Activated: false,
+	}
+
+	err := user.Password.Set("pa55word-for-erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := oidcLogin(t, app, idp, map[string]interface{}{
+		"sub":            "subject-6",
+		"email":          "erin@example.com",
+		"email_verified": true,
+	})
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	_, err = app.models.Identities.GetUser(idp.Issuer(), "subject-6")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got error %v, want %v", err, data.ErrRecordNotFound)
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	app, idp := newOIDCTestApplication(t)
+
+	user := &data.User{
+		Name:      "Carol",
+		Email:     "carol@example.com",
+		Activated: true,
+	}
+
+	err := user.Password.Set("pa55word-for-carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"existing email", map[string]interface{}{"sub": "subject-3", "email": "carol@example.com", "email_verified": false}},
+		{"new email", map[string]interface{}{"sub": "subject-4", "email": "dave@example.com", "email_verified": false}},
+		{"missing email", map[string]interface{}{"sub": "subject-5", "email_verified": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := oidcLogin(t, app, idp, tt.claims)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want %d", rr.Code, http.StatusUnauthorized)
+			}
+
+			_, err := app.models.Identities.GetUser(idp.Issuer(), tt.claims["sub"].(string))
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("got error %v, want %v", err, data.ErrRecordNotFound)
+			}
+		})
+	}
+
+	_, err = app.models.Users.GetByEmail("dave@example.com")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got error %v, want %v", err, data.ErrRecordNotFound)
+	}
+}
@@ -1,12 +1,64 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/mycok/sunrise-api/internal/data"
 	"github.com/mycok/sunrise-api/internal/validator"
 )
 
+// The readUserParam() helper retrieves the user identified by the id URL parameter
+// on the /v1/users/:id/... routes, sending the client a 404 Not Found response and
+// returning nil if there is no such user.
+func (app *application) readUserParam(rw http.ResponseWriter, r *http.Request) *data.User {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(rw, r)
+
+		return nil
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) listPermissionsForUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
 func (app *application) addPermissionForUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
 	var input struct {
 		Permission string `json:"permission"`
 	}
@@ -18,15 +70,19 @@ func (app *application) addPermissionForUserHandler(rw http.ResponseWriter, r *h
 		return
 	}
 
+	// Check the code against the registry of known permissions, so that an unknown code
+	// is reported to the client instead of silently granting nothing.
 	v := validator.New()
-	if v.Check(input.Permission != "", "permission", "must be provided"); !v.Valid() {
+
+	v.Check(input.Permission != "", "permission", "must be provided")
+	v.Check(data.IsKnownPermission(input.Permission), "permission", "must be a known permission code")
+
+	if !v.Valid() {
 		app.failedValidationResponse(rw, r, v.Errors)
 
 		return
 	}
 
-	user := app.contextGetUser(r)
-
 	err = app.models.Permissions.AddForUser(user.ID, input.Permission)
 	if err != nil {
 		app.serverErrorResponse(rw, r, err)
@@ -39,3 +95,54 @@ func (app *application) addPermissionForUserHandler(rw http.ResponseWriter, r *h
 		app.serverErrorResponse(rw, r, err)
 	}
 }
+
+func (app *application) removePermissionForUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Permission string `json:"permission"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Permission != "", "permission", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Permissions.RemoveForUser(user.ID, input.Permission)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "permission successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// listPermissionsHandler() returns the catalog of permission codes that can be granted,
+// along with their descriptions.
+func (app *application) listPermissionsHandler(rw http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(rw, http.StatusOK, envelope{"permissions": data.KnownPermissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
@@ -22,14 +22,81 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requiresPermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requiresPermission("movies:write", app.deleteMovieHandler))
 
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requiresPermission("users:admin", app.listUsersHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.RegisterUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmUserEmailHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.currentUserOr(app.requiresAuthentication(app.showCurrentUserHandler), app.requiresPermission("users:admin", app.showUserHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.updateCurrentUserHandler)), app.requiresPermission("users:admin", app.updateUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.currentUserOr(app.requiresAuthentication(app.requiresUserSession(app.deleteCurrentUserHandler)), app.requiresPermission("users:admin", app.deleteUserHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/sessions", app.currentUserOr(app.requiresAuthentication(app.requiresUserSession(app.listSessionsHandler)), nil))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/sessions/:session_id", app.currentUserOr(app.requiresAuthentication(app.requiresUserSession(app.deleteSessionHandler)), nil))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/api-keys", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.listAPIKeysHandler)), nil))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/api-keys", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.createAPIKeyHandler)), nil))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/api-keys/:key_id", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.deleteAPIKeyHandler)), nil))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/2fa", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.createTwoFactorHandler)), nil))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/2fa/confirm", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.confirmTwoFactorHandler)), nil))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/2fa", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.deleteTwoFactorHandler)), nil))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/oauth-clients", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.listOAuthClientsHandler)), nil))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/oauth-clients", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.createOAuthClientHandler)), nil))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/oauth-clients/:oauth_client_id", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.deleteOAuthClientHandler)), nil))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/export", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.createDataExportHandler)), nil))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/export", app.currentUserOr(app.requiresActivatedUser(app.requiresUserSession(app.showDataExportHandler)), nil))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/erasure", app.currentUserOr(app.requiresAuthentication(app.requiresUserSession(app.eraseCurrentUserHandler)), app.requiresPermission("users:admin", app.eraseUserHandler)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/suspension", app.requiresPermission("users:admin", app.suspendUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/suspension", app.requiresPermission("users:admin", app.unsuspendUserHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/impersonate", app.requiresPermission("users:impersonate", app.requiresUserSession(app.createImpersonationTokenHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/permissions", app.requiresPermission("users:admin", app.listPermissionsForUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/permissions", app.requiresPermission("users:admin", app.addPermissionForUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions", app.requiresPermission("users:admin", app.removePermissionForUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/roles", app.requiresPermission("users:admin", app.listRolesForUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/roles", app.requiresPermission("users:admin", app.addRoleForUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/roles", app.requiresPermission("users:admin", app.removeRoleForUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/certificates", app.requiresPermission("users:admin", app.listCertificatesForUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/certificates", app.requiresPermission("users:admin", app.addCertificateForUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/certificates/:certificate_id", app.requiresPermission("users:admin", app.removeCertificateForUserHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/invitations", app.requiresPermission("users:admin", app.createInvitationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requiresActivatedUser(app.listPermissionsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requiresPermission("users:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/roles", app.requiresPermission("users:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles/:id", app.requiresPermission("users:admin", app.showRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/roles/:id", app.requiresPermission("users:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.requiresPermission("users:admin", app.deleteRoleHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requiresAuthentication(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requiresAuthentication(app.requiresUserSession(app.deleteAllAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc", app.createOIDCLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/oidc/callback", app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/permissions", app.addPermissionForUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/authorize", app.requiresActivatedUser(app.requiresUserSession(app.showOAuthAuthorizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/authorize", app.requiresActivatedUser(app.requiresUserSession(app.createOAuthAuthorizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/token", app.createOAuthTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/introspect", app.introspectOAuthTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/revoke", app.revokeOAuthTokenHandler)
 
 	router.HandlerFunc(http.MethodGet, "/debug/metrics", app.requiresPermission("metrics:view", expvar.Handler().ServeHTTP))
 
-	return app.metrics(app.recoverFromPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.recoverFromPanic(app.enableCORS(app.rateLimit(app.authenticate(app.logImpersonation(router))))))
 }
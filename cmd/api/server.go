@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -22,6 +25,31 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// When a client CA bundle is configured, clients may present a certificate signed by
+	// one of its CAs to authenticate. Clients without a certificate can still connect and
+	// authenticate with a token instead.
+	if app.config.tls.clientCAFile != "" {
+		if app.config.tls.certFile == "" {
+			return errors.New("a TLS certificate is required to verify client certificates")
+		}
+
+		bundle, err := os.ReadFile(app.config.tls.clientCAFile)
+		if err != nil {
+			return err
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(bundle) {
+			return errors.New("no certificates found in the client CA bundle")
+		}
+
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -68,13 +96,21 @@ func (app *application) serve() error {
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  strconv.FormatBool(app.config.tls.certFile != ""),
 	})
 
 	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
 	// good thing and an indication that the graceful shutdown has started. So we check
 	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
+	var err error
+
+	if app.config.tls.certFile != "" {
+		err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
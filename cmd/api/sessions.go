@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mycok/sunrise-api/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// listSessionsHandler() lists the sessions of the current user along with the device
+// details they were last used from. Sessions are based on refresh tokens, which are
+// stored whether authentication tokens are opaque or signed.
+func (app *application) listSessionsHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// deleteSessionHandler() ends one of the current user's sessions by its ID, so that a
+// session can be ended without knowing its plaintext tokens. Every token issued within
+// the session is revoked, including signed authentication tokens.
+func (app *application) deleteSessionHandler(rw http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("session_id")
+
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteSessionForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	app.syncRevocations()
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
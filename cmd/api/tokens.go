@@ -33,14 +33,33 @@ func (app *application) createAuthenticationTokenHandler(rw http.ResponseWriter,
 		return
 	}
 
+	// Refuse the attempt outright while the email address or client is locked out. The
+	// response is the same as for invalid credentials, so that the lockout doesn't reveal
+	// whether an account exists for the address.
+	locked, err := app.loginLocked(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if locked {
+		app.invalidCredentialsResponse(rw, r)
+
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client (we will create this helper in a moment).
+	// found, then we still check the password against a dummy hash so that the response
+	// takes as long as it would for a registered address, record the failed attempt and
+	// call the app.invalidCredentialsResponse() helper to send a 401 Unauthorized
+	// response to the client.
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(rw, r)
+			data.SimulatePasswordCheck(input.Password)
+			app.failedLoginResponse(rw, r, input.Email, nil)
 		default:
 			app.serverErrorResponse(rw, r, err)
 		}
@@ -56,27 +75,525 @@ func (app *application) createAuthenticationTokenHandler(rw http.ResponseWriter,
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, then we record the failed attempt and send the
+	// same response again.
 	if !match {
-		app.invalidCredentialsResponse(rw, r)
+		app.failedLoginResponse(rw, r, input.Email, user)
+
+		return
+	}
+
+	// The plaintext password is only available during login, so this is when a hash
+	// made with an outdated algorithm or parameters is upgraded. A failure here doesn't
+	// stop the user from logging in, as the old hash remains valid.
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(user, input.Password)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+
+	// Otherwise, if the password is correct, we complete the login.
+	app.completeLogin(rw, r, user)
+}
+
+// The rehashPassword() helper replaces the password hash of a user with one made by the
+// configured hasher. An edit conflict means the user was updated concurrently, in which
+// case the hash is left to be upgraded on a later login.
+func (app *application) rehashPassword(user *data.User, plaintextPassword string) error {
+	err := user.Password.Set(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
+
+	return nil
+}
+
+// The completeLogin() helper finishes signing in a user whose identity has been proven,
+// unless their account is suspended. Users with two-factor authentication enabled
+// receive a short-lived pending token that must be exchanged along with a valid code,
+// and everyone else is issued a new session.
+func (app *application) completeLogin(rw http.ResponseWriter, r *http.Request, user *data.User) {
+	// Suspended users have proven who they are by now, so it's safe to tell them why
+	// they can't log in.
+	if user.IsSuspended() {
+		app.suspendedAccountResponse(rw, r)
 
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	enabled, err := app.models.TwoFactor.IsEnabled(user.ID)
 	if err != nil {
 		app.serverErrorResponse(rw, r, err)
 
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	if enabled {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactorPending)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		err = app.writeJSON(rw, http.StatusAccepted, envelope{"two_factor_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	// A completed login clears the failed attempts for the email address. Users with
+	// two-factor authentication keep theirs until they have passed that step too, so
+	// that knowing the password isn't enough to reset the lockout on their codes.
+	err = app.models.LoginAttempts.DeleteForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.startSession(rw, r, user.ID)
+}
+
+// The startSession() helper starts a new token family for the user and responds with a
+// short-lived authentication token along with a refresh token.
+func (app *application) startSession(rw http.ResponseWriter, r *http.Request, userID int64) {
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	env, err := app.newSessionTokens(r, userID, family)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	app.writeJSON(rw, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(rw, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// The newSessionTokens() helper issues a short-lived authentication token and a refresh
+// token for the user within the given token family, and returns them in an envelope
+// that is ready to be sent to the client.
+func (app *application) newSessionTokens(r *http.Request, userID int64, family string) (envelope, error) {
+	// In signed mode the authentication token is a signed token which isn't stored,
+	// while the refresh token remains an opaque token in the tokens table. The ID of the
+	// signed token is stored with the refresh token instead, so that it can be revoked
+	// when the session is ended.
+	if app.signingKeys != nil {
+		authToken, err := app.newSignedToken(userID, family)
+		if err != nil {
+			return nil, err
+		}
+
+		refreshToken, err := app.models.Tokens.NewRefresh(userID, app.config.tokens.refreshTTL, family, r.UserAgent(), app.clientIP(r), authToken.ID, &authToken.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		return envelope{"authentication_token": authToken, "refresh_token": refreshToken}, nil
+	}
+
+	authToken, err := app.models.Tokens.NewSession(userID, app.config.tokens.accessTTL, data.ScopeAuthentication, family, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := app.models.Tokens.NewRefresh(userID, app.config.tokens.refreshTTL, family, r.UserAgent(), app.clientIP(r), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"authentication_token": authToken, "refresh_token": refreshToken}, nil
+}
+
+// refreshAuthenticationTokenHandler() exchanges a refresh token for a new authentication
+// token and refresh token. Each refresh token can only be used once; presenting one
+// that has already been rotated revokes every token in its family.
+func (app *application) refreshAuthenticationTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidatePlainTextToken(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	token := &data.Token{Hash: data.HashPlainTextToken(input.RefreshToken)}
+
+	err = app.models.Tokens.Rotate(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reused, token family revoked", map[string]string{
+				"client_ip": app.clientIP(r),
+			})
+			app.invalidAuthTokenResponse(rw, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthTokenResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	env, err := app.newSessionTokens(r, token.UserID, token.Family)
 	if err != nil {
 		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	// The response is the same whether or not a reset email is sent, so that the
+	// endpoint can't be used to discover which email addresses are registered.
+	env := envelope{"message": "if an account exists for this email address, an email will be sent to it containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// Only activated users are allowed to reset their password.
+	if user != nil && user.Activated {
+		// Create a new password reset token with a 45-minute expiry time.
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		// Email the user with their password reset token.
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken": token.PlainText,
+			}
+
+			err := app.mailer.Send(user.Email, "user_password_reset.go.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	err = app.writeJSON(rw, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
 	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	// The response is the same whether the address is unknown, already activated or
+	// sent a new token, so that the endpoint can't be used to discover accounts.
+	env := envelope{"message": "if an inactive account exists for this email address, an email will be sent to it containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// Only users who haven't been activated yet are sent a new activation token.
+	if user != nil && !user.Activated {
+		// Revoke any activation tokens that were previously issued for the user, so that
+		// only the most recent one can be used.
+		err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		// Email the user with their new activation token.
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.PlainText,
+				"userID":          user.ID,
+			}
+
+			err := app.mailer.Send(user.Email, "user_activation_reminder.go.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(rw, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler() revokes the bearer token that was used to
+// authenticate the request, effectively logging the client out.
+func (app *application) deleteAuthenticationTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+
+	// Signed tokens aren't stored, so they are added to the revocation denylist until
+	// they expire instead.
+	if claims := app.contextGetClaims(r); claims != nil {
+		err := app.models.Revocations.Insert(claims.ID, time.Unix(claims.ExpiresAt, 0))
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		app.revocations.add(claims.ID, time.Unix(claims.ExpiresAt, 0))
+
+		app.background(func() {
+			err := app.models.Revocations.DeleteExpired()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	} else {
+		err := app.models.Tokens.DeleteByHash(token.Hash)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthTokenResponse(rw, r)
+			default:
+				app.serverErrorResponse(rw, r, err)
+			}
+
+			return
+		}
+	}
+
+	// Revoke the refresh tokens that were issued alongside the token too, so that the
+	// session can't be resumed.
+	err := app.models.Tokens.DeleteFamily(token.Family)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.syncRevocations()
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler() revokes every authentication token issued to
+// the current user, logging them out of all their sessions.
+func (app *application) deleteAllAuthenticationTokensHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeAuthentication, data.ScopeRefresh)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.syncRevocations()
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// createMagicLinkTokenHandler() emails a single-use login token to a user, which lets
+// them log in without their password. The response is the same whether or not the
+// email address is registered, so that it can't be used to discover accounts.
+func (app *application) createMagicLinkTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	env := envelope{"message": "if an account exists for this email address, a login link will be sent to it"}
+
+	// Login tokens are only sent to activated users, as following the link also proves
+	// ownership of the email address but shouldn't stand in for activating the account.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if user != nil && user.Activated {
+		// Revoke any login tokens that were previously issued for the user, so that
+		// only the most recent link can be used.
+		err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeLogin)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 15*time.Minute, data.ScopeLogin)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"loginToken": token.PlainText,
+			}
+
+			err := app.mailer.Send(user.Email, "user_magic_link.go.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(rw, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// exchangeMagicLinkTokenHandler() trades a login token for an authentication token and
+// a refresh token, in the same way as logging in with a password. The login token is
+// deleted, so that each link can only be used once.
+func (app *application) exchangeMagicLinkTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlainTextToken string `json:"token"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidatePlainTextToken(v, input.PlainTextToken); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(input.PlainTextToken, data.ScopeLogin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	// Deleting the token also guards against it being exchanged twice concurrently, as
+	// only one of the requests will find it to delete.
+	err = app.models.Tokens.DeleteByHash(data.HashPlainTextToken(input.PlainTextToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	app.completeLogin(rw, r, user)
 }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data"
+	"github.com/mycok/sunrise-api/internal/totp"
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// createTwoFactorHandler() starts the enrolment of the current user in two-factor
+// authentication. The returned secret and otpauth URI are added to an authenticator app,
+// and the enrolment only takes effect once it has been confirmed with a code.
+func (app *application) createTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	user, err := app.contextGetFullUser(r)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if twoFactor != nil && twoFactor.Confirmed {
+		v := validator.New()
+		v.AddError("two_factor", "two-factor authentication is already enabled")
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.models.TwoFactor.SetPending(user.ID, secret)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"secret":      totp.EncodeSecret(secret),
+		"otpauth_uri": totp.URI("Sunrise", user.Email, secret),
+	}
+
+	err = app.writeJSON(rw, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// confirmTwoFactorHandler() enables two-factor authentication for the current user once
+// they have proven that their authenticator app produces valid codes, and returns a set
+// of recovery codes. The recovery codes are only ever included in this response.
+func (app *application) confirmTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor", "two-factor authentication has not been set up")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	if twoFactor.Confirmed {
+		v.AddError("two_factor", "two-factor authentication is already enabled")
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	step, ok := totp.Validate(input.Code, twoFactor.Secret, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	_, err = app.models.TwoFactor.UseStep(user.ID, step)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes(10)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.models.TwoFactor.Enable(user.ID, recoveryCodes)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// deleteTwoFactorHandler() disables two-factor authentication for the current user. A
+// valid code or recovery code is required, so that a stolen session alone can't be used
+// to weaken the account.
+func (app *application) deleteTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTwoFactorInput(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifyTwoFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.TwoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// createTwoFactorAuthenticationTokenHandler() exchanges a two-factor pending token,
+// along with a valid code or recovery code, for an authentication token and a refresh
+// token. A pending token can only be used once, whether or not the code is valid, and
+// wrong codes count towards the lockout of the user's email address.
+func (app *application) createTwoFactorAuthenticationTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token        string `json:"two_factor_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePlainTextToken(v, input.Token)
+	data.ValidateTwoFactorInput(v, input.Code, input.RecoveryCode)
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(input.Token, data.ScopeTwoFactorPending)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.models.Tokens.DeleteByHash(data.HashPlainTextToken(input.Token))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	// Codes are guessed against the same lockout as passwords, so that a pending token
+	// can't be used to try codes any faster than passwords can be tried.
+	locked, err := app.loginLocked(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if locked {
+		app.invalidCredentialsResponse(rw, r)
+
+		return
+	}
+
+	ok, err := app.verifyTwoFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	if !ok {
+		app.failedLoginResponse(rw, r, user.Email, user)
+
+		return
+	}
+
+	err = app.models.LoginAttempts.DeleteForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.startSession(rw, r, user.ID)
+}
+
+// The verifyTwoFactor() helper checks a code from the authenticator app of a user, or
+// failing that one of their recovery codes. Each code can only be used once.
+func (app *application) verifyTwoFactor(userID int64, code, recoveryCode string) (bool, error) {
+	twoFactor, err := app.models.TwoFactor.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	if !twoFactor.Confirmed {
+		return false, nil
+	}
+
+	if code == "" {
+		return app.models.TwoFactor.UseRecoveryCode(userID, recoveryCode)
+	}
+
+	step, ok := totp.Validate(code, twoFactor.Secret, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.TwoFactor.UseStep(userID, step)
+}
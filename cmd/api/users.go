@@ -11,9 +11,10 @@ import (
 
 func (app *application) RegisterUserHandler(rw http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name            string `json:"name"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		InvitationToken string `json:"invitation_token"`
 	}
 
 	err := app.readJSON(rw, r, &input)
@@ -23,6 +24,18 @@ func (app *application) RegisterUserHandler(rw http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if input.InvitationToken != "" {
+		app.registerInvitedUser(rw, r, input.Name, input.Email, input.Password, input.InvitationToken)
+
+		return
+	}
+
+	if app.config.registration.closed {
+		app.registrationClosedResponse(rw, r)
+
+		return
+	}
+
 	user := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
@@ -52,6 +65,21 @@ func (app *application) RegisterUserHandler(rw http.ResponseWriter, r *http.Requ
 		// If we get a ErrDuplicateEmail error, use the v.AddError() method to manually // add a message to the validator instance, and then call our
 		// failedValidationResponse() helper.
 		case errors.Is(err, data.ErrDuplicateEmail):
+			// In enumeration-safe mode the response doesn't reveal that the address is
+			// registered. The owner of the address is told about the attempt instead.
+			if app.config.registration.enumerationSafe {
+				app.background(func() {
+					err := app.mailer.Send(user.Email, "user_registration_exists.go.tmpl", nil)
+					if err != nil {
+						app.logger.PrintError(err, nil)
+					}
+				})
+
+				app.registrationAcceptedResponse(rw, r)
+
+				return
+			}
+
 			v.AddError("email", "a user with this email already exists")
 			app.failedValidationResponse(rw, r, v.Errors)
 		default:
@@ -86,6 +114,12 @@ func (app *application) RegisterUserHandler(rw http.ResponseWriter, r *http.Requ
 		}
 	})
 
+	if app.config.registration.enumerationSafe {
+		app.registrationAcceptedResponse(rw, r)
+
+		return
+	}
+
 	// Send the client a 202 Accepted status code to indicate that the request has been
 	// accepted for processing but the processing has not yet been completed
 	err = app.writeJSON(rw, http.StatusAccepted, envelope{"user": user}, nil)
@@ -94,6 +128,18 @@ func (app *application) RegisterUserHandler(rw http.ResponseWriter, r *http.Requ
 	}
 }
 
+// The registrationAcceptedResponse() helper sends the response used for every
+// registration in enumeration-safe mode, whether or not the email address was already
+// registered.
+func (app *application) registrationAcceptedResponse(rw http.ResponseWriter, r *http.Request) {
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err := app.writeJSON(rw, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
 func (app *application) activateUserHandler(rw http.ResponseWriter, r *http.Request) {
 	// Parse the plain_text token from the request
 	var input struct {
@@ -171,3 +217,601 @@ func (app *application) activateUserHandler(rw http.ResponseWriter, r *http.Requ
 		app.serverErrorResponse(rw, r, err)
 	}
 }
+
+func (app *application) updateUserPasswordHandler(rw http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's new password and password reset token.
+	var input struct {
+		Password       string `json:"password"`
+		PlainTextToken string `json:"token"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePassword(v, input.Password)
+	data.ValidatePlainTextToken(v, input.PlainTextToken)
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	// Retrieve the details of the user associated with the password reset token,
+	// returning an error message to the client if no matching record was found.
+	user, err := app.models.Users.GetForToken(input.PlainTextToken, data.ScopePasswordReset)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	// Now that the user is known, check the new password against the password policy.
+	if data.ValidatePasswordPolicy(v, input.Password, user); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	// Set the new password for the user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// Save the updated user record in our database, checking for any edit conflicts as
+	// normal. Update() also increments the version number of the record.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	// If everything was successful, then delete all password reset tokens for the user,
+	// along with any authentication and refresh tokens that were issued with the old
+	// password.
+	err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	app.syncRevocations()
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user, err := app.contextGetFullUser(r)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user, err := app.contextGetFullUser(r)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	// Declare an input struct to hold the expected data from the client. The current
+	// password is only required when the password itself is being changed.
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	err = app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Password != nil {
+		v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+		if !v.Valid() {
+			app.failedValidationResponse(rw, r, v.Errors)
+
+			return
+		}
+
+		match, err := user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		if !match {
+			v.AddError("current_password", "does not match your current password")
+			app.failedValidationResponse(rw, r, v.Errors)
+
+			return
+		}
+
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+	}
+
+	data.ValidateUser(v, user)
+
+	// An email change only takes effect once the new address has been confirmed, so the
+	// new address is validated on its own rather than being set on the user.
+	emailChanged := input.Email != nil && *input.Email != user.Email
+	if emailChanged {
+		data.ValidateEmail(v, *input.Email)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	if emailChanged {
+		_, err = app.models.Users.GetByEmail(*input.Email)
+		switch {
+		case err == nil:
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(rw, r, v.Errors)
+
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	// A changed password invalidates any password reset tokens that are still pending,
+	// and ends every session that was started with the old password, including the
+	// current one, in the same way as a password reset.
+	if input.Password != nil {
+		err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		app.syncRevocations()
+	}
+
+	env := envelope{"user": user}
+
+	if emailChanged {
+		err = app.models.Users.SetPendingEmail(user.ID, *input.Email)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		// Revoke any earlier email change tokens so that only the latest requested
+		// address can be confirmed.
+		err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeEmailChange)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		// Send the confirmation token to the new address, which proves that the user
+		// actually owns it.
+		email := *input.Email
+		app.background(func() {
+			data := map[string]interface{}{
+				"emailChangeToken": token.PlainText,
+			}
+
+			err := app.mailer.Send(email, "user_email_change.go.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+
+		env["message"] = "an email will be sent to your new address containing confirmation instructions"
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) deleteCurrentUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Users.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	app.syncRevocations()
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "user account successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) confirmUserEmailHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlainTextToken string `json:"token"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePlainTextToken(v, input.PlainTextToken); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(input.PlainTextToken, data.ScopeEmailChange)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired token")
+			app.failedValidationResponse(rw, r, v.Errors)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.models.Users.ConfirmEmailChange(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(rw, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) listUsersHandler(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email      string
+		Activated  *bool
+		Permission string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Permission = app.readString(qs, "permission", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if input.Permission != "" {
+		v.Check(data.IsKnownPermission(input.Permission), "permission", "must be a known permission code")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	users, metadata, err := app.models.Users.List(input.Email, input.Activated, input.Permission, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"metadata": metadata, "users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) showUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	err := app.writeJSON(rw, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// updateUserHandler() lets an administrator rename a user, or activate or deactivate
+// their account. A user who is activated this way is treated the same as one who
+// activated their own account, and a deactivated user is logged out of every session.
+func (app *application) updateUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Activated *bool   `json:"activated"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	wasActivated := user.Activated
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	switch {
+	case user.Activated && !wasActivated:
+		err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(rw, r, err)
+
+			return
+		}
+
+		err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	case !user.Activated && wasActivated:
+		// A deactivated user is locked out in the same way as a suspended one, so none
+		// of their tokens can be used until they are activated again.
+		err = app.revokeAllTokens(user.ID)
+	}
+
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(rw http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	err = app.models.Users.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	app.syncRevocations()
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"message": "user account successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+// suspendUserHandler() locks a user out of their account and revokes all of their
+// tokens, including their signed authentication tokens, their API keys, the access
+// tokens issued to their OAuth clients and the invitations they have sent.
+func (app *application) suspendUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(rw, r, &input)
+	if err != nil {
+		app.badRequestResponse(rw, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes")
+	v.Check(user.ID != app.contextGetUser(r).ID, "user", "you can't suspend your own account")
+
+	if !v.Valid() {
+		app.failedValidationResponse(rw, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Users.Suspend(user, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.revokeAllTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
+
+func (app *application) unsuspendUserHandler(rw http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(rw, r)
+	if user == nil {
+		return
+	}
+
+	if !user.IsSuspended() {
+		app.notFoundResponse(rw, r)
+
+		return
+	}
+
+	err := app.models.Users.Unsuspend(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(rw, r)
+		default:
+			app.serverErrorResponse(rw, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(rw, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(rw, r, err)
+	}
+}
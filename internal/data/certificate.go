@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// UserCertificate type maps a client certificate to the user it authenticates as. The
+// subject is either the distinguished name of the certificate, such as "CN=indexer",
+// or one of its subject alternative names, written as "DNS:indexer.internal",
+// "email:indexer@example.com" or "URI:spiffe://example.com/indexer".
+type UserCertificate struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateUserCertificate(v *validator.Validator, certificate *UserCertificate) {
+	v.Check(certificate.Subject != "", "subject", "must be provided")
+	v.Check(len(certificate.Subject) <= 500, "subject", "must not be more than 500 bytes")
+
+	v.Check(
+		strings.Contains(certificate.Subject, "=") ||
+			strings.HasPrefix(certificate.Subject, "DNS:") ||
+			strings.HasPrefix(certificate.Subject, "email:") ||
+			strings.HasPrefix(certificate.Subject, "URI:"),
+		"subject",
+		`must be a distinguished name, or a name prefixed with "DNS:", "email:" or "URI:"`,
+	)
+}
+
+// CertificateSubjects() returns the distinguished name and the subject alternative
+// names of a certificate, in the form in which they are stored as subjects.
+func CertificateSubjects(cert *x509.Certificate) []string {
+	subjects := []string{cert.Subject.String()}
+
+	for _, name := range cert.DNSNames {
+		subjects = append(subjects, "DNS:"+name)
+	}
+
+	for _, address := range cert.EmailAddresses {
+		subjects = append(subjects, "email:"+address)
+	}
+
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, "URI:"+uri.String())
+	}
+
+	return subjects
+}
+
+type UserCertificateModel struct {
+	DB *sql.DB
+}
+
+// Insert() maps a certificate subject to a user. Each subject can only be mapped to one
+// user.
+func (m UserCertificateModel) Insert(certificate *UserCertificate) error {
+	query := `
+			INSERT INTO user_certificates (user_id, subject)
+			VALUES ($1, $2)
+			RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, certificate.UserID, certificate.Subject).Scan(&certificate.ID, &certificate.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_certificates_subject_key"`:
+			return ErrDuplicateCertificate
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForUser() returns the certificate subjects mapped to a specific user.
+func (m UserCertificateModel) GetAllForUser(userID int64) ([]*UserCertificate, error) {
+	query := `
+			SELECT id, user_id, subject, created_at
+			FROM user_certificates
+			WHERE user_id = $1
+			ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	certificates := []*UserCertificate{}
+
+	for rows.Next() {
+		var certificate UserCertificate
+
+		err := rows.Scan(
+			&certificate.ID,
+			&certificate.UserID,
+			&certificate.Subject,
+			&certificate.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		certificates = append(certificates, &certificate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return certificates, nil
+}
+
+// GetUser() returns the user that any of the given subjects is mapped to. If the
+// subjects are mapped to more than one user, the certificate is ambiguous and an
+// ErrRecordNotFound error is returned as if none of them were mapped.
+func (m UserCertificateModel) GetUser(subjects []string) (*User, error) {
+	query := `
+			SELECT DISTINCT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.suspended_at, users.suspension_reason, users.version
+			FROM users
+			INNER JOIN user_certificates ON user_certificates.user_id = users.id
+			WHERE user_certificates.subject = ANY($1)
+			LIMIT 2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(subjects))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.SuspendedAt,
+			&user.SuspensionReason,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(users) != 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return users[0], nil
+}
+
+// DeleteForUser() removes a certificate subject, provided that it's mapped to the
+// specified user.
+func (m UserCertificateModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			DELETE FROM user_certificates
+			WHERE id = $1
+			AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
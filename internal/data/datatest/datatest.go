@@ -0,0 +1,150 @@
+// Package datatest provides a database for tests of code that uses the data models. Each
+// test gets its own schema with every migration applied, in the database given by the
+// SUNRISE_API_TEST_POSTGRES_DSN environment variable. Tests are skipped when it isn't
+// set. The citext extension must already be installed in the database.
+package datatest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// DSNEnvVar is the environment variable holding the DSN of the test database.
+const DSNEnvVar = "SUNRISE_API_TEST_POSTGRES_DSN"
+
+// Open() returns a connection pool to a new schema in the test database, which has had
+// every migration applied. The schema is dropped when the test finishes.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnvVar)
+	if dsn == "" {
+		t.Skipf("%s is not set", DSNEnvVar)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { admin.Close() })
+
+	randomBytes := make([]byte, 8)
+
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := "test_" + hex.EncodeToString(randomBytes)
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Every connection in the pool must use the new schema, so it's set as a run-time
+	// parameter in the DSN rather than with a SET statement. The public schema stays on
+	// the path for the citext extension.
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	err = migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// withSearchPath() adds the search_path run-time parameter to a DSN, which may be either
+// a URL or a list of key/value pairs.
+func withSearchPath(dsn, searchPath string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			qs := u.Query()
+			qs.Set("search_path", searchPath)
+			u.RawQuery = qs.Encode()
+
+			return u.String()
+		}
+	}
+
+	return dsn + " search_path='" + searchPath + "'"
+}
+
+// migrate() applies every up migration, in order.
+func migrate(db *sql.DB) error {
+	dir, err := migrationsDir()
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		query, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(string(query))
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+
+	return nil
+}
+
+// migrationsDir() finds the migrations directory at the root of the module, by looking
+// for go.mod in the working directory of the test and each of its parents.
+func migrationsDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		_, err := os.Stat(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			return filepath.Join(dir, "migrations"), nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("datatest: go.mod not found")
+		}
+
+		dir = parent
+	}
+}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type DataExportModel struct {
+	DB *sql.DB
+}
+
+// New() stores an archive of the data held about a user, and returns the token that it
+// can be downloaded with until it expires.
+func (m DataExportModel) New(userID int64, archive []byte, timeToLive time.Duration) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, "")
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+			INSERT INTO data_exports (hash, user_id, archive, expiry)
+			VALUES ($1, $2, $3, $4)
+			RETURNING created_at`
+
+	args := []interface{}{token.Hash, token.UserID, archive, token.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Take() returns the archive of an unexpired export, provided that it belongs to the
+// specified user, and removes it so that its token can't be used again.
+func (m DataExportModel) Take(userID int64, plainTextToken string) ([]byte, error) {
+	query := `
+			DELETE FROM data_exports
+			WHERE hash = $1
+			AND user_id = $2
+			AND expiry > $3
+			RETURNING archive`
+
+	args := []interface{}{HashPlainTextToken(plainTextToken), userID, time.Now()}
+
+	var archive []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&archive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return archive, nil
+}
+
+// DeleteExpired() removes the exports which can no longer be downloaded.
+func (m DataExportModel) DeleteExpired() error {
+	query := `
+			DELETE FROM data_exports
+			WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+
+	return err
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// Hasher is implemented by each password hashing algorithm. Hashes are stored in a
+// self-describing format which identifies the algorithm and its parameters, so that a
+// hash can always be verified by the algorithm that produced it.
+type Hasher interface {
+	// Hash() returns the hash of a plaintext password.
+	Hash(plaintextPassword string) ([]byte, error)
+
+	// Matches() checks a plaintext password against a hash produced by this algorithm.
+	Matches(plaintextPassword string, hash []byte) (bool, error)
+
+	// NeedsRehash() returns true if a hash was made by a different algorithm, or with
+	// different parameters to the ones the hasher is currently configured with.
+	NeedsRehash(hash []byte) bool
+}
+
+// PasswordHasher is the hasher used to hash new passwords. It is configured once on
+// startup, before any requests are served.
+var PasswordHasher Hasher = DefaultArgon2idHasher
+
+// hasherFor() returns a hasher that can verify the given hash, identified by the prefix
+// of the hash.
+func hasherFor(hash []byte) (Hasher, error) {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		return Argon2idHasher{}, nil
+	case bytes.HasPrefix(hash, []byte("$2a$")), bytes.HasPrefix(hash, []byte("$2b$")), bytes.HasPrefix(hash, []byte("$2y$")):
+		return BcryptHasher{}, nil
+	default:
+		return nil, ErrUnknownPasswordHash
+	}
+}
+
+// Argon2idHasher hashes passwords with argon2id, storing them in the PHC string format,
+// e.g. "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>". The memory is given in KiB.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idHasher uses the parameters recommended by RFC 9106 for systems which
+// can't spare 2 GiB of memory for each hash.
+var DefaultArgon2idHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (h Argon2idHasher) Hash(plaintextPassword string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintextPassword), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	hash := fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key),
+	)
+
+	return []byte(hash), nil
+}
+
+// Matches() hashes the plaintext password with the parameters and salt stored in the
+// hash, rather than the ones the hasher is configured with.
+func (h Argon2idHasher) Matches(plaintextPassword string, hash []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plaintextPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, salt, _, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		params.KeyLength != h.KeyLength ||
+		uint32(len(salt)) != h.SaltLength
+}
+
+func decodeArgon2idHash(hash []byte) (params Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err = phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	key, err = phcEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt. Passwords used to be hashed with it, so it
+// is kept to verify the hashes that haven't been upgraded yet.
+type BcryptHasher struct {
+	Cost int
+}
+
+// LegacyBcryptHasher uses the cost that passwords used to be hashed with.
+var LegacyBcryptHasher = BcryptHasher{Cost: 12}
+
+func (h BcryptHasher) Hash(plaintextPassword string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintextPassword), h.Cost)
+}
+
+func (h BcryptHasher) Matches(plaintextPassword string, hash []byte) (bool, error) {
+	// bcrypt ignores everything after the first 72 bytes, which would let a longer
+	// password match a hash made from just its beginning.
+	if len(plaintextPassword) > 72 {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+
+	return err != nil || cost != h.Cost
+}
+
+// DummyPasswordHasher is the hasher that SimulatePasswordCheck() uses, which is the
+// PasswordHasher when it's nil. While most accounts still have a legacy hash, it can be
+// set to LegacyBcryptHasher so that checks for unknown email addresses take as long as
+// checks for those accounts. Either way, until every hash has been upgraded the accounts
+// hashed with the other algorithm can be told apart from unknown addresses by timing;
+// this is accepted for the length of the migration.
+var DummyPasswordHasher Hasher
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// SimulatePasswordCheck() compares a plaintext password against a dummy hash and
+// discards the result. It is used when there is no user to check the password of, so
+// that the response takes as long as it would if the user existed.
+func SimulatePasswordCheck(plaintextPassword string) {
+	hasher := DummyPasswordHasher
+	if hasher == nil {
+		hasher = PasswordHasher
+	}
+
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = hasher.Hash("sunrise-dummy-password")
+	})
+
+	_, _ = hasher.Matches(plaintextPassword, dummyPasswordHash)
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idHasher uses tiny parameters, so that the tests don't spend their time
+// hashing.
+var testArgon2idHasher = Argon2idHasher{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hash, err := testArgon2idHasher.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		t.Fatalf("unexpected error decoding the hash: %v", err)
+	}
+
+	if params != testArgon2idHasher || len(salt) != 16 || len(key) != 32 {
+		t.Errorf("got parameters %+v with a %d byte salt and %d byte key", params, len(salt), len(key))
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"same password", "pa55word", true},
+		{"different password", "pa55word!", false},
+		{"different case", "PA55WORD", false},
+		{"empty password", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A hasher with other parameters still checks hashes with the stored ones.
+			got, err := DefaultArgon2idHasher.Matches(tt.password, hash)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArgon2idHashInvalid(t *testing.T) {
+	const (
+		salt = "c29tZXNhbHRzb21lc2FsdA"
+		key  = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5"
+	)
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"extra part", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{"argon2i", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key},
+		{"older version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"missing version", "$argon2id$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{"invalid parameters", "$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key},
+		{"padded salt", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "==$" + key},
+		{"invalid key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := decodeArgon2idHash([]byte(tt.hash))
+			if !errors.Is(err, ErrUnknownPasswordHash) {
+				t.Errorf("got error %v, want %v", err, ErrUnknownPasswordHash)
+			}
+
+			if !testArgon2idHasher.NeedsRehash([]byte(tt.hash)) {
+				t.Error("expected an invalid hash to need rehashing")
+			}
+		})
+	}
+}
+
+func TestArgon2idHasherNeedsRehash(t *testing.T) {
+	hash, err := testArgon2idHasher.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa55word"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(change func(h *Argon2idHasher)) Argon2idHasher {
+		h := testArgon2idHasher
+		change(&h)
+
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		hasher Argon2idHasher
+		hash   []byte
+		want   bool
+	}{
+		{"same parameters", testArgon2idHasher, hash, false},
+		{"more memory", with(func(h *Argon2idHasher) { h.Memory = 128 }), hash, true},
+		{"more iterations", with(func(h *Argon2idHasher) { h.Iterations = 2 }), hash, true},
+		{"more parallelism", with(func(h *Argon2idHasher) { h.Parallelism = 2 }), hash, true},
+		{"longer salt", with(func(h *Argon2idHasher) { h.SaltLength = 32 }), hash, true},
+		{"longer key", with(func(h *Argon2idHasher) { h.KeyLength = 64 }), hash, true},
+		{"bcrypt hash", testArgon2idHasher, bcryptHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := BcryptHasher{Cost: bcrypt.MinCost}
+
+	long := strings.Repeat("a", 72)
+
+	hash, err := hasher.Hash(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"same password", long, true},
+		{"different password", strings.Repeat("b", 72), false},
+		{"shorter password", long[:71], false},
+		// bcrypt itself would match this against the hash of its first 72 bytes.
+		{"longer than 72 bytes", long + "b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hasher.Matches(tt.password, hash)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Error("expected a hash with the same cost not to need rehashing")
+	}
+
+	if !(BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Error("expected a hash with a different cost to need rehashing")
+	}
+}
+
+func TestPasswordUpgradeFromBcrypt(t *testing.T) {
+	hasher := PasswordHasher
+	defer func() { PasswordHasher = hasher }()
+
+	PasswordHasher = testArgon2idHasher
+
+	legacy, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := password{hash: legacy}
+
+	// A legacy hash is still checked with bcrypt, but needs to be upgraded.
+	matches, err := p.Matches("pa55word")
+	if err != nil || !matches {
+		t.Fatalf("got %t and error %v checking the legacy hash, want a match", matches, err)
+	}
+
+	if !p.NeedsRehash() {
+		t.Fatal("expected the legacy hash to need rehashing")
+	}
+
+	err = p.Set("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(p.hash, []byte("$argon2id$")) {
+		t.Fatalf("got hash %s, want an argon2id hash", p.hash)
+	}
+
+	matches, err = p.Matches("pa55word")
+	if err != nil || !matches {
+		t.Errorf("got %t and error %v checking the upgraded hash, want a match", matches, err)
+	}
+
+	if p.NeedsRehash() {
+		t.Error("expected the upgraded hash not to need rehashing")
+	}
+
+	_, err = (&password{hash: []byte("$1$md5-crypt")}).Matches("pa55word")
+	if !errors.Is(err, ErrUnknownPasswordHash) {
+		t.Errorf("got error %v for an unknown hash, want %v", err, ErrUnknownPasswordHash)
+	}
+}
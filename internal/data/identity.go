@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity type links a user to their account at an external identity provider, which
+// is identified by the issuer and subject of the ID tokens that the provider issues.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert() links an external identity to a user.
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+			INSERT INTO user_identities (user_id, issuer, subject, email)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at`
+
+	args := []interface{}{identity.UserID, identity.Issuer, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// Provision() creates an activated user for a new external identity in a single
+// transaction, assigning them the given roles and linking the identity to them, so that
+// a failure part of the way through doesn't leave a user who can't be provisioned again.
+func (m IdentityModel) Provision(user *User, identity *Identity, roles ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	err = addRolesForUser(ctx, tx, user.ID, roles...)
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+
+	query := `
+			INSERT INTO user_identities (user_id, issuer, subject, email)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at`
+
+	args := []interface{}{identity.UserID, identity.Issuer, identity.Subject, identity.Email}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUser() returns the user that an external identity is linked to, or an
+// ErrRecordNotFound error if it hasn't been linked to anyone.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+			SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.suspended_at, users.suspension_reason, users.version
+			FROM users
+			INNER JOIN user_identities ON user_identities.user_id = users.id
+			WHERE user_identities.issuer = $1
+			AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAllForUser() returns the external identities that are linked to a specific user.
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+			SELECT id, user_id, issuer, subject, email, created_at
+			FROM user_identities
+			WHERE user_id = $1
+			ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// OIDCLogin type holds the secrets of a login with an external identity provider that
+// is in progress, which are needed to complete it once the provider redirects back.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+type OIDCLoginModel struct {
+	DB *sql.DB
+}
+
+// Insert() stores a login that is in progress. Only the hash of the state is stored, as
+// it is what the client presents to complete the login.
+func (m OIDCLoginModel) Insert(login *OIDCLogin, timeToLive time.Duration) error {
+	query := `
+			INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expiry)
+			VALUES ($1, $2, $3, $4)`
+
+	args := []interface{}{HashPlainTextToken(login.State), login.Nonce, login.CodeVerifier, time.Now().Add(timeToLive)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+// Take() removes and returns the unexpired login with the given state, so that each
+// login can only be completed once. Expired logins are cleared out at the same time.
+func (m OIDCLoginModel) Take(state string) (*OIDCLogin, error) {
+	query := `
+			DELETE FROM oidc_logins
+			WHERE state_hash = $1
+			OR expiry < NOW()
+			RETURNING state_hash = $1 AND expiry >= NOW(), nonce, code_verifier`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, HashPlainTextToken(state))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var login *OIDCLogin
+
+	for rows.Next() {
+		var matched bool
+		var nonce, codeVerifier string
+
+		err = rows.Scan(&matched, &nonce, &codeVerifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched {
+			login = &OIDCLogin{State: state, Nonce: nonce, CodeVerifier: codeVerifier}
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if login == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return login, nil
+}
@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Invitation type holds an invitation for someone to register, which an administrator
+// sends to their email address. The user who registers with it is activated straight
+// away, and granted the permissions and roles of the invitation.
+type Invitation struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"`
+	Roles       []string    `json:"roles"`
+	Expiry      time.Time   `json:"expiry"`
+	InvitedBy   int64       `json:"-"`
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range invitation.Permissions {
+		v.Check(IsKnownPermission(code), "permissions", fmt.Sprintf("%q is not a known permission code", code))
+	}
+
+	v.Check(validator.Unique(invitation.Roles), "roles", "must not contain duplicate values")
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// New() creates an invitation along with the token that it is sent with. The token
+// belongs to the user who sent the invitation, and removing the token removes the
+// invitation.
+func (m InvitationModel) New(invitation *Invitation, timeToLive time.Duration) (*Token, error) {
+	token, err := generateToken(invitation.InvitedBy, timeToLive, ScopeInvitation)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.Expiry = *token.Expiry
+
+	query := `
+			WITH token AS (
+				INSERT INTO tokens (hash, user_id, expiry, scope)
+				VALUES ($1, $2, $3, $4)
+				RETURNING hash
+			)
+			INSERT INTO invitations (token_hash, email, permissions, roles)
+			SELECT hash, $5, $6, $7
+			FROM token
+			RETURNING id, created_at`
+
+	args := []interface{}{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		invitation.Email,
+		pq.Array([]string(invitation.Permissions)),
+		pq.Array(invitation.Roles),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetForToken() returns the unexpired invitation that was sent with a token.
+func (m InvitationModel) GetForToken(plainTextToken string) (*Invitation, error) {
+	query := `
+			SELECT invitations.id, invitations.created_at, invitations.email, invitations.permissions,
+			invitations.roles, tokens.expiry, tokens.user_id
+			FROM invitations
+			INNER JOIN tokens ON tokens.hash = invitations.token_hash
+			WHERE tokens.hash = $1
+			AND tokens.scope = $2
+			AND tokens.expiry > $3`
+
+	args := []interface{}{HashPlainTextToken(plainTextToken), ScopeInvitation, time.Now()}
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Email,
+		pq.Array((*[]string)(&invitation.Permissions)),
+		pq.Array(&invitation.Roles),
+		&invitation.Expiry,
+		&invitation.InvitedBy,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// GetAllForUser() returns the invitations that a user has sent which haven't been used
+// or removed yet, most recent first.
+func (m InvitationModel) GetAllForUser(userID int64) ([]*Invitation, error) {
+	query := `
+			SELECT invitations.id, invitations.created_at, invitations.email, invitations.permissions,
+			invitations.roles, tokens.expiry, tokens.user_id
+			FROM invitations
+			INNER JOIN tokens ON tokens.hash = invitations.token_hash
+			WHERE tokens.user_id = $1
+			AND tokens.scope = $2
+			ORDER BY invitations.created_at DESC, invitations.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeInvitation)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.CreatedAt,
+			&invitation.Email,
+			pq.Array((*[]string)(&invitation.Permissions)),
+			pq.Array(&invitation.Roles),
+			&invitation.Expiry,
+			&invitation.InvitedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Accept() registers a user with an invitation in a single transaction. The invitation
+// token is removed, which also removes the invitation, and the user is created with the
+// "movies:read" permission that every activated user has, along with the permissions
+// and roles of the invitation. An ErrRecordNotFound error is returned if the token has
+// expired or has already been used, in which case nothing is changed.
+func (m InvitationModel) Accept(invitation *Invitation, plainTextToken string, user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+			DELETE FROM tokens
+			WHERE hash = $1
+			AND scope = $2
+			AND expiry > $3`
+
+	result, err := tx.ExecContext(ctx, query, HashPlainTextToken(plainTextToken), ScopeInvitation, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	err = addPermissionsForUser(ctx, tx, user.ID, append([]string{"movies:read"}, invitation.Permissions...)...)
+	if err != nil {
+		return err
+	}
+
+	err = addRolesForUser(ctx, tx, user.ID, invitation.Roles...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LoginAttempts type summarises the failed login attempts made recently for an email
+// address and from a client IP address.
+type LoginAttempts struct {
+	EmailFailures    int
+	LastEmailFailure time.Time
+	IPFailures       int
+	LastIPFailure    time.Time
+}
+
+// LoginAttempt type holds a single failed login attempt.
+type LoginAttempt struct {
+	CreatedAt time.Time `json:"created_at"`
+	ClientIP  string    `json:"client_ip"`
+}
+
+// LoginAttemptModel records failed login attempts, which are used to slow down and
+// eventually lock out clients that are guessing passwords.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Insert() records a failed login attempt and returns the number of failed attempts
+// made for the email address since the given time, including this one.
+func (m LoginAttemptModel) Insert(email, clientIP string, since time.Time) (int, error) {
+	// The select can't see the row added by the insert, so it is counted separately.
+	query := `
+			WITH attempt AS (
+				INSERT INTO login_attempts (email, client_ip)
+				VALUES ($1, $2)
+			)
+			SELECT COUNT(*) + 1
+			FROM login_attempts
+			WHERE email = $1
+			AND created_at > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+
+	err := m.DB.QueryRowContext(ctx, query, email, clientIP, since).Scan(&failures)
+
+	return failures, err
+}
+
+// GetRecent() returns a summary of the failed login attempts made for the email address
+// and from the client IP address since the given time.
+func (m LoginAttemptModel) GetRecent(email, clientIP string, since time.Time) (*LoginAttempts, error) {
+	query := `
+			SELECT
+				COUNT(*) FILTER (WHERE email = $1),
+				COALESCE(MAX(created_at) FILTER (WHERE email = $1), 'epoch'),
+				COUNT(*) FILTER (WHERE client_ip = $2),
+				COALESCE(MAX(created_at) FILTER (WHERE client_ip = $2), 'epoch')
+			FROM login_attempts
+			WHERE (email = $1 OR client_ip = $2)
+			AND created_at > $3`
+
+	var attempts LoginAttempts
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email, clientIP, since).Scan(
+		&attempts.EmailFailures,
+		&attempts.LastEmailFailure,
+		&attempts.IPFailures,
+		&attempts.LastIPFailure,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+// GetAllForEmail() returns the failed login attempts recorded for an email address which
+// haven't been removed yet, most recent first.
+func (m LoginAttemptModel) GetAllForEmail(email string) ([]*LoginAttempt, error) {
+	query := `
+			SELECT created_at, client_ip
+			FROM login_attempts
+			WHERE email = $1
+			ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attempts := []*LoginAttempt{}
+
+	for rows.Next() {
+		var attempt LoginAttempt
+
+		err := rows.Scan(&attempt.CreatedAt, &attempt.ClientIP)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, &attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// DeleteForEmail() clears the failed login attempts for an email address, which is done
+// once the owner of the account has logged in successfully.
+func (m LoginAttemptModel) DeleteForEmail(email string) error {
+	query := `
+			DELETE FROM login_attempts
+			WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+
+	return err
+}
+
+// DeleteExpired() removes the failed login attempts made before the given time, which
+// no longer count towards a lockout.
+func (m LoginAttemptModel) DeleteExpired(before time.Time) error {
+	query := `
+			DELETE FROM login_attempts
+			WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, before)
+
+	return err
+}
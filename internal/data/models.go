@@ -6,23 +6,47 @@ import (
 )
 
 var (
-	ErrRecordNotFound = errors.New("record not found")
-	ErrEditConflict   = errors.New("edit conflict")
-	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrRecordNotFound       = errors.New("record not found")
+	ErrEditConflict         = errors.New("edit conflict")
+	ErrDuplicateEmail       = errors.New("duplicate email")
+	ErrDuplicateRole        = errors.New("duplicate role name")
+	ErrDuplicateCertificate = errors.New("duplicate certificate subject")
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies        MovieModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	Roles         RoleModel
+	Revocations   RevocationModel
+	TwoFactor     TwoFactorModel
+	LoginAttempts LoginAttemptModel
+	Identities    IdentityModel
+	OIDCLogins    OIDCLoginModel
+	OAuthClients  OAuthClientModel
+	OAuthCodes    OAuthCodeModel
+	Invitations   InvitationModel
+	DataExports   DataExportModel
+	Certificates  UserCertificateModel
 }
 
 func New(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Users:         UserModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Roles:         RoleModel{DB: db},
+		Revocations:   RevocationModel{DB: db},
+		TwoFactor:     TwoFactorModel{DB: db},
+		LoginAttempts: LoginAttemptModel{DB: db},
+		Identities:    IdentityModel{DB: db},
+		OIDCLogins:    OIDCLoginModel{DB: db},
+		OAuthClients:  OAuthClientModel{DB: db},
+		OAuthCodes:    OAuthCodeModel{DB: db},
+		Invitations:   InvitationModel{DB: db},
+		DataExports:   DataExportModel{DB: db},
+		Certificates:  UserCertificateModel{DB: db},
 	}
 }
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// OAuthClient type holds a third-party application registered to use the API on behalf
+// of users. Confidential clients hold a secret and can also act on behalf of the user
+// that registered them, while public clients can only use the authorization code flow.
+type OAuthClient struct {
+	ID           int64       `json:"id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	ClientID     string      `json:"client_id"`
+	Secret       string      `json:"client_secret,omitempty"`
+	SecretHash   []byte      `json:"-"`
+	Name         string      `json:"name"`
+	RedirectURIs []string    `json:"redirect_uris"`
+	Scopes       Permissions `json:"scopes"`
+	UserID       int64       `json:"-"`
+}
+
+// IsConfidential() returns true if the client authenticates with a secret.
+func (c *OAuthClient) IsConfidential() bool {
+	return c.SecretHash != nil
+}
+
+// SecretMatches() checks the secret presented by a confidential client.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	return c.IsConfidential() && subtle.ConstantTimeCompare(c.SecretHash, HashPlainTextToken(secret)) == 1
+}
+
+// AllowsRedirectURI() returns true if the redirect URI exactly matches one that was
+// registered for the client.
+func (c *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	return validator.In(redirectURI, c.RedirectURIs...)
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes")
+
+	v.Check(len(client.RedirectURIs) >= 1, "redirect_uris", "must contain at least 1 URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+
+	for _, redirectURI := range client.RedirectURIs {
+		v.Check(isValidRedirectURI(redirectURI), "redirect_uris", fmt.Sprintf("%q must be an absolute https URL, or an http URL on localhost", redirectURI))
+	}
+
+	v.Check(len(client.Scopes) >= 1, "scopes", "must contain at least 1 permission")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+
+	for _, code := range client.Scopes {
+		v.Check(IsKnownPermission(code), "scopes", fmt.Sprintf("%q is not a known permission code", code))
+	}
+}
+
+// isValidRedirectURI() checks that a redirect URI is absolute and has no fragment, and
+// that it's only served over plain http when it points at the user's own machine.
+func isValidRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		return validator.In(u.Hostname(), "localhost", "127.0.0.1", "::1")
+	default:
+		return false
+	}
+}
+
+type OAuthClientModel struct {
+	DB *sql.DB
+}
+
+// New() registers a client for the user. A random client ID is generated, along with a
+// secret if the client is confidential. The plaintext secret is only held in the struct
+// that is returned.
+func (m OAuthClientModel) New(client *OAuthClient, confidential bool) error {
+	clientID, err := generateToken(client.UserID, 0, "")
+	if err != nil {
+		return err
+	}
+
+	client.ClientID = clientID.PlainText
+
+	if confidential {
+		secret, err := generateToken(client.UserID, 0, "")
+		if err != nil {
+			return err
+		}
+
+		client.Secret = secret.PlainText
+		client.SecretHash = secret.Hash
+	}
+
+	query := `
+			INSERT INTO oauth_clients (client_id, secret_hash, name, redirect_uris, scopes, user_id)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at`
+
+	args := []interface{}{
+		client.ClientID,
+		client.SecretHash,
+		client.Name,
+		pq.Array(client.RedirectURIs),
+		pq.Array([]string(client.Scopes)),
+		client.UserID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.ID, &client.CreatedAt)
+}
+
+// GetByClientID() returns the client with the given public client ID.
+func (m OAuthClientModel) GetByClientID(clientID string) (*OAuthClient, error) {
+	query := `
+			SELECT id, created_at, client_id, secret_hash, name, redirect_uris, scopes, user_id
+			FROM oauth_clients
+			WHERE client_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	clients, err := m.queryClients(ctx, query, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(clients) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return clients[0], nil
+}
+
+// GetAllForUser() returns the clients registered by a specific user.
+func (m OAuthClientModel) GetAllForUser(userID int64) ([]*OAuthClient, error) {
+	query := `
+			SELECT id, created_at, client_id, secret_hash, name, redirect_uris, scopes, user_id
+			FROM oauth_clients
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryClients(ctx, query, userID)
+}
+
+func (m OAuthClientModel) queryClients(ctx context.Context, query string, args ...interface{}) ([]*OAuthClient, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		var client OAuthClient
+
+		err := rows.Scan(
+			&client.ID,
+			&client.CreatedAt,
+			&client.ClientID,
+			&client.SecretHash,
+			&client.Name,
+			pq.Array(&client.RedirectURIs),
+			pq.Array((*[]string)(&client.Scopes)),
+			&client.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, &client)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteForUser() removes a client, provided that it was registered by the specified
+// user. The tokens issued to the client are removed along with it.
+func (m OAuthClientModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			DELETE FROM oauth_clients
+			WHERE id = $1
+			AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// OAuthCode type holds an authorization code, which a client exchanges for an access
+// token once the user has consented to its request.
+type OAuthCode struct {
+	PlainText     string
+	ClientID      int64
+	UserID        int64
+	RedirectURI   string
+	CodeChallenge string
+	Permissions   Permissions
+	Expiry        time.Time
+}
+
+type OAuthCodeModel struct {
+	DB *sql.DB
+}
+
+// New() creates an authorization code which expires after the given duration.
+func (m OAuthCodeModel) New(code *OAuthCode, timeToLive time.Duration) error {
+	token, err := generateToken(code.UserID, timeToLive, "")
+	if err != nil {
+		return err
+	}
+
+	code.PlainText = token.PlainText
+	code.Expiry = *token.Expiry
+
+	query := `
+			INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, code_challenge, permissions, expiry)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []interface{}{
+		token.Hash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.CodeChallenge,
+		pq.Array([]string(code.Permissions)),
+		code.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+// Take() removes and returns an unexpired authorization code, so that each code can only
+// be exchanged once.
+func (m OAuthCodeModel) Take(plainTextCode string) (*OAuthCode, error) {
+	query := `
+			DELETE FROM oauth_codes
+			WHERE hash = $1
+			RETURNING client_id, user_id, redirect_uri, code_challenge, permissions, expiry`
+
+	code := OAuthCode{PlainText: plainTextCode}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, HashPlainTextToken(plainTextCode)).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.CodeChallenge,
+		pq.Array((*[]string)(&code.Permissions)),
+		&code.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(code.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &code, nil
+}
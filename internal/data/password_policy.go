@@ -0,0 +1,205 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+//go:embed "common_passwords.txt"
+var commonPasswordsFile string
+
+// PasswordRules holds the policy that new passwords must satisfy, on top of the length
+// checks made by ValidatePassword().
+type PasswordRules struct {
+	// MinEntropy is the minimum estimated strength of a password, in bits.
+	MinEntropy float64
+
+	// CommonPasswords holds passwords, in lower case, that are too common to be used.
+	CommonPasswords map[string]bool
+
+	// Breached holds passwords that have appeared in data breaches. The check is skipped
+	// when it is nil.
+	Breached *BreachedPasswords
+}
+
+// PasswordPolicy is the policy applied to new passwords. It is configured once on
+// startup, before any requests are served.
+var PasswordPolicy = PasswordRules{
+	MinEntropy:      45,
+	CommonPasswords: parseCommonPasswords(commonPasswordsFile),
+}
+
+func parseCommonPasswords(contents string) map[string]bool {
+	passwords := make(map[string]bool)
+
+	for _, line := range strings.Split(contents, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+
+	return passwords
+}
+
+// ValidatePasswordPolicy() checks a new password against the PasswordPolicy. The user
+// is used to reject passwords which contain their name or email address, so it should
+// hold the details that the password is being set alongside.
+func ValidatePasswordPolicy(v *validator.Validator, password string, user *User) {
+	lowered := strings.ToLower(password)
+
+	v.Check(!PasswordPolicy.CommonPasswords[lowered], "password", "must not be a commonly used password")
+
+	for _, part := range personalParts(user) {
+		v.Check(!strings.Contains(lowered, part), "password", "must not contain your name or email address")
+	}
+
+	v.Check(
+		passwordEntropy(password) >= PasswordPolicy.MinEntropy,
+		"password", "is too easy to guess, try a longer password or mixing in other kinds of characters",
+	)
+
+	if PasswordPolicy.Breached != nil {
+		v.Check(!PasswordPolicy.Breached.Contains(password), "password", "has appeared in a data breach and must not be used")
+	}
+}
+
+// personalParts() returns the parts of the name and email address of a user that a
+// password must not contain. Very short parts are ignored, as they would rule out too
+// many passwords.
+func personalParts(user *User) []string {
+	var parts []string
+
+	fields := strings.Fields(strings.ToLower(user.Name))
+
+	if at := strings.LastIndex(user.Email, "@"); at > 0 {
+		fields = append(fields, strings.ToLower(user.Email[:at]))
+	}
+
+	for _, field := range fields {
+		if len(field) >= 3 {
+			parts = append(parts, field)
+		}
+	}
+
+	return parts
+}
+
+// passwordEntropy() estimates the strength of a password in bits, from its length and
+// the kinds of characters it uses. A character that repeats the one before it adds no
+// strength.
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	length := 0
+	previous := rune(-1)
+
+	for _, r := range password {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+
+		if r != previous {
+			length++
+		}
+
+		previous = r
+	}
+
+	pool := 0
+
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// BreachedPasswords holds the SHA-1 hashes of passwords which have appeared in data
+// breaches. The hashes are indexed by their first five hex characters, in the same way
+// as k-anonymity range queries, so that only the suffixes sharing a prefix are compared.
+type BreachedPasswords struct {
+	ranges map[string]map[string]bool
+}
+
+// LoadBreachedPasswords() reads a file of upper case hex SHA-1 hashes, one per line and
+// optionally followed by ":<count>", which is the format of the downloadable Pwned
+// Passwords lists.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	breached := &BreachedPasswords{ranges: make(map[string]map[string]bool)}
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		hash := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if i := strings.IndexByte(hash, ':'); i >= 0 {
+			hash = hash[:i]
+		}
+
+		if hash == "" {
+			continue
+		}
+
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-1 hash", path, line)
+		}
+
+		prefix, suffix := hash[:5], hash[5:]
+
+		if breached.ranges[prefix] == nil {
+			breached.ranges[prefix] = make(map[string]bool)
+		}
+
+		breached.ranges[prefix][suffix] = true
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return breached, nil
+}
+
+// Contains() returns true if the password appears in the list.
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	return b.ranges[hash[:5]][hash[5:]]
+}
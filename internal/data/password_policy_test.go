@@ -0,0 +1,103 @@
+package data
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "breached.txt")
+
+	// The SHA-1 hash of "Sunrise-Breached-Passw0rd!", in lower case and with a count,
+	// as both are accepted.
+	err := ioutil.WriteFile(path, []byte("8efb616702027f669efb1a803cd6ef75d7f1f8e4:12\n\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := PasswordPolicy
+	defer func() { PasswordPolicy = policy }()
+
+	PasswordPolicy.Breached = breached
+
+	user := &User{Name: "Alice Smith", Email: "alice.smith@example.com"}
+
+	const (
+		common   = "must not be a commonly used password"
+		personal = "must not contain your name or email address"
+		weak     = "is too easy to guess, try a longer password or mixing in other kinds of characters"
+		leaked   = "has appeared in a data breach and must not be used"
+	)
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"strong", "Tr0ub4dor&3-velvet", ""},
+		{"long passphrase", "correct horse battery staple", ""},
+		{"common", "password", common},
+		{"common in another case", "QWERTY123", common},
+		{"contains first name", "Sunny-alice-Day-42!", personal},
+		{"contains last name in another case", "Brave-SMITH-walks-7?", personal},
+		{"contains email local part", "x-alice.smith-x-9Z!", personal},
+		{"short name parts are allowed", "Al-Bz-Qm-Vx-Rt-9!", ""},
+		{"too short to be strong", "Ab1!xy", weak},
+		{"single character class", "abcdefghi", weak},
+		{"repeated characters", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", weak},
+		{"breached", "Sunrise-Breached-Passw0rd!", leaked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			ValidatePasswordPolicy(v, tt.password, user)
+
+			if got := v.Errors["password"]; got != tt.want {
+				t.Errorf("got error %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"aaaa", 4.700439718141092},
+		{"abcd", 4 * 4.700439718141092},
+		{"ab12", 4 * 5.169925001442312},
+	}
+
+	for _, tt := range tests {
+		if got := passwordEntropy(tt.password); got < tt.want-0.0001 || got > tt.want+0.0001 {
+			t.Errorf("passwordEntropy(%q) = %f, want %f", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsInvalidHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+
+	err := ioutil.WriteFile(path, []byte("8EFB616702027F669EFB1A803CD6EF75D7F1F8E4\nnot-a-hash\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadBreachedPasswords(path)
+	if err == nil {
+		t.Error("expected an error for an invalid hash")
+	}
+}
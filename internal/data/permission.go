@@ -3,19 +3,57 @@ package data
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// PermissionDefinition type describes a permission code that can be granted to users.
+type PermissionDefinition struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// KnownPermissions is the registry of every permission code that can be granted. Codes
+// ending in "*" are wildcards which grant every code that shares their prefix.
+var KnownPermissions = []PermissionDefinition{
+	{Code: "*", Description: "Grants every permission"},
+	{Code: "movies:*", Description: "Grants every movies permission"},
+	{Code: "movies:read", Description: "View movies in the catalogue"},
+	{Code: "movies:write", Description: "Create, update and delete movies in the catalogue"},
+	{Code: "metrics:*", Description: "Grants every metrics permission"},
+	{Code: "metrics:view", Description: "View the application metrics"},
+	{Code: "users:*", Description: "Grants every users permission"},
+	{Code: "users:admin", Description: "Manage other users, their permissions and roles"},
+	{Code: "users:impersonate", Description: "Act as other users to see what they see"},
+}
+
+// IsKnownPermission() returns true if the code is in the KnownPermissions registry.
+func IsKnownPermission(code string) bool {
+	for i := range KnownPermissions {
+		if KnownPermissions[i].Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Permissions type holds the permission files from the db
 type Permissions []string
 
+// Include() returns true if any of the permissions grants the code, either by matching
+// it exactly or through a wildcard such as "movies:*" or "*".
 func (p Permissions) Include(code string) bool {
 	for i := range p {
 		if p[i] == code {
 			return true
 		}
+
+		if strings.HasSuffix(p[i], "*") && strings.HasPrefix(code, strings.TrimSuffix(p[i], "*")) {
+			return true
+		}
 	}
 
 	return false
@@ -25,13 +63,20 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser() returns the union of the permissions granted directly to a user and
+// those granted through the roles the user holds.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 			SELECT permissions.code
 			FROM permissions
 			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-			INNER JOIN users ON users_permissions.user_id = users.id
-			WHERE users.id = $1`
+			WHERE users_permissions.user_id = $1
+			UNION
+			SELECT permissions.code
+			FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+			WHERE users_roles.user_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -43,7 +88,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 	defer rows.Close()
 
-	var permissions Permissions
+	permissions := Permissions{}
 
 	for rows.Next() {
 		var Permission string
@@ -63,13 +108,15 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-// AddForUser() adds permisssion codes to a specific user
+// AddForUser() adds permisssion codes to a specific user. Codes that the user already
+// holds are left as they are.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 			INSERT INTO users_permissions
 			SELECT $1, permissions.id 
 			FROM permissions 
-			WHERE permissions.code = ANY($2)`
+			WHERE permissions.code = ANY($2)
+			ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -78,3 +125,48 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 
 	return err
 }
+
+// addPermissionsForUser() adds permission codes to a specific user in the same way as
+// AddForUser(), as part of the given transaction.
+func addPermissionsForUser(ctx context.Context, tx *sql.Tx, userID int64, codes ...string) error {
+	query := `
+			INSERT INTO users_permissions
+			SELECT $1, permissions.id
+			FROM permissions
+			WHERE permissions.code = ANY($2)
+			ON CONFLICT DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+
+	return err
+}
+
+// RemoveForUser() removes permission codes from a specific user, returning an
+// ErrRecordNotFound error if the user held none of them.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+			DELETE FROM users_permissions
+			USING permissions
+			WHERE users_permissions.permission_id = permissions.id
+			AND users_permissions.user_id = $1
+			AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
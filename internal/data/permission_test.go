@@ -0,0 +1,45 @@
+package data
+
+import "testing"
+
+func TestPermissionsInclude(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions Permissions
+		code        string
+		want        bool
+	}{
+		{"exact match", Permissions{"movies:read"}, "movies:read", true},
+		{"no match", Permissions{"movies:read"}, "movies:write", false},
+		{"no permissions", Permissions{}, "movies:read", false},
+		{"nil permissions", nil, "movies:read", false},
+		{"one of several", Permissions{"users:admin", "movies:write"}, "movies:write", true},
+		{"resource wildcard", Permissions{"movies:*"}, "movies:write", true},
+		{"resource wildcard for another resource", Permissions{"movies:*"}, "users:admin", false},
+		{"resource wildcard needs the separator", Permissions{"movies:*"}, "moviesx:read", false},
+		{"global wildcard", Permissions{"*"}, "users:admin", true},
+		{"wildcard only at the end", Permissions{"*:read"}, "movies:read", false},
+		{"prefix without wildcard", Permissions{"movies:"}, "movies:read", false},
+		{"code longer than permission", Permissions{"movies:read"}, "movies:read:all", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.permissions.Include(tt.code); got != tt.want {
+				t.Errorf("%v.Include(%q) = %t, want %t", tt.permissions, tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsKnownPermission(t *testing.T) {
+	for _, permission := range KnownPermissions {
+		if !IsKnownPermission(permission.Code) {
+			t.Errorf("%q should be known", permission.Code)
+		}
+	}
+
+	if IsKnownPermission("movies:delete-everything") {
+		t.Error("unexpected known permission")
+	}
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevocationModel manages the denylist of signed tokens which have been revoked before
+// their expiry. Entries only need to be kept until the token they refer to expires.
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds the ID of a signed token to the denylist.
+func (m RevocationModel) Insert(id string, expiry time.Time) error {
+	query := `
+			INSERT INTO revoked_tokens (id, expiry)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, expiry)
+
+	return err
+}
+
+// GetAll() returns the IDs of the revoked tokens which haven't expired yet, along with
+// their expiry.
+func (m RevocationModel) GetAll() (map[string]time.Time, error) {
+	query := `
+			SELECT id, expiry
+			FROM revoked_tokens
+			WHERE expiry > $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revocations := make(map[string]time.Time)
+
+	for rows.Next() {
+		var id string
+		var expiry time.Time
+
+		err := rows.Scan(&id, &expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		revocations[id] = expiry
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// DeleteExpired() removes the entries for tokens that have expired anyway.
+func (m RevocationModel) DeleteExpired() error {
+	query := `
+			DELETE FROM revoked_tokens
+			WHERE expiry < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+
+	return err
+}
@@ -0,0 +1,345 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Role type groups a set of permission codes under a name, so that they can be granted
+// to users together.
+type Role struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"-"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+	Version     int32       `json:"version"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes")
+
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 bytes")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range role.Permissions {
+		v.Check(IsKnownPermission(code), "permissions", fmt.Sprintf("%q is not a known permission code", code))
+	}
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new role along with its permissions. Both inserts are performed in a
+// single transaction so that a role is never saved without its permissions.
+func (m RoleModel) Insert(role *Role) error {
+	query := `
+			INSERT INTO roles (name, description)
+			VALUES ($1, $2)
+			RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt, &role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+
+	err = setRolePermissions(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m RoleModel) Get(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT roles.id, roles.created_at, roles.name, roles.description, roles.version,
+				COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+			FROM roles
+			LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+			WHERE roles.id = $1
+			GROUP BY roles.id`
+
+	var role Role
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&role.ID,
+		&role.CreatedAt,
+		&role.Name,
+		&role.Description,
+		&role.Version,
+		pq.Array((*[]string)(&role.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// GetAll() returns every role along with its permissions, ordered by name.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+			SELECT roles.id, roles.created_at, roles.name, roles.description, roles.version,
+				COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+			FROM roles
+			LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+			GROUP BY roles.id
+			ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryRoles(ctx, query)
+}
+
+// GetAllForUser() returns the roles that have been assigned to a specific user.
+func (m RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
+	query := `
+			SELECT roles.id, roles.created_at, roles.name, roles.description, roles.version,
+				COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+			FROM roles
+			INNER JOIN users_roles ON users_roles.role_id = roles.id
+			LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+			WHERE users_roles.user_id = $1
+			GROUP BY roles.id
+			ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryRoles(ctx, query, userID)
+}
+
+func (m RoleModel) queryRoles(ctx context.Context, query string, args ...interface{}) ([]*Role, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(
+			&role.ID,
+			&role.CreatedAt,
+			&role.Name,
+			&role.Description,
+			&role.Version,
+			pq.Array((*[]string)(&role.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Update() saves the details and permissions of a role, checking against the version
+// field to help prevent any race conditions.
+func (m RoleModel) Update(role *Role) error {
+	query := `
+			UPDATE roles
+			SET name = $1, description = $2, version = version + 1
+			WHERE id = $3
+			AND version = $4
+			RETURNING version`
+
+	args := []interface{}{role.Name, role.Description, role.ID, role.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = setRolePermissions(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			DELETE FROM roles
+			WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddForUser() assigns roles, identified by name, to a specific user. Roles that the
+// user already holds are left as they are.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+			INSERT INTO users_roles
+			SELECT $1, roles.id
+			FROM roles
+			WHERE roles.name = ANY($2)
+			ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+
+	return err
+}
+
+// addRolesForUser() assigns roles to a specific user in the same way as AddForUser(),
+// as part of the given transaction.
+func addRolesForUser(ctx context.Context, tx *sql.Tx, userID int64, names ...string) error {
+	query := `
+			INSERT INTO users_roles
+			SELECT $1, roles.id
+			FROM roles
+			WHERE roles.name = ANY($2)
+			ON CONFLICT DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(names))
+
+	return err
+}
+
+// RemoveForUser() unassigns roles, identified by name, from a specific user, returning
+// an ErrRecordNotFound error if the user held none of them.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+			DELETE FROM users_roles
+			USING roles
+			WHERE users_roles.role_id = roles.id
+			AND users_roles.user_id = $1
+			AND roles.name = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// setRolePermissions() replaces the permissions of a role with the codes in its
+// Permissions field, as part of the given transaction.
+func setRolePermissions(ctx context.Context, tx *sql.Tx, role *Role) error {
+	query := `
+			DELETE FROM roles_permissions
+			WHERE role_id = $1`
+
+	_, err := tx.ExecContext(ctx, query, role.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO roles_permissions
+			SELECT $1, permissions.id
+			FROM permissions
+			WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array([]string(role.Permissions)))
+
+	return err
+}
@@ -6,33 +6,125 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/mycok/sunrise-api/internal/validator"
+
+	"github.com/lib/pq"
 )
 
 const (
-	ScopeActivation     = "activation"
-	ScopeAuthentication = "authentication"
+	ScopeActivation       = "activation"
+	ScopeAuthentication   = "authentication"
+	ScopePasswordReset    = "password-reset"
+	ScopeEmailChange      = "email-change"
+	ScopeAPIKey           = "api-key"
+	ScopeRefresh          = "refresh"
+	ScopeTwoFactorPending = "2fa-pending"
+	ScopeLogin            = "login"
+	ScopeOAuthAccess      = "oauth-access"
+	ScopeImpersonation    = "impersonation"
+	ScopeInvitation       = "invitation"
+
+	// ScopeCertificate is the scope given to requests authenticated with a client
+	// certificate. No tokens of this scope are ever stored.
+	ScopeCertificate = "certificate"
 )
 
+// ErrTokenReused is returned when a refresh token that has already been rotated is
+// presented again, which means that it has most likely been stolen.
+var ErrTokenReused = errors.New("token reused")
+
 // Token type holds the data for an individual token.
-// JSON tags control how the type fields appear when encoded into JSON
+// JSON tags control how the type fields appear when encoded into JSON. Only the plaintext
+// and expiry are encoded, as tokens are encoded into the responses that issue them; the
+// rest of a token's data is described by TokenInfo.
 type Token struct {
-	PlainText string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID           int64       `json:"-"`
+	PlainText    string      `json:"token,omitempty"`
+	Hash         []byte      `json:"-"`
+	UserID       int64       `json:"-"`
+	CreatedAt    time.Time   `json:"-"`
+	LastUsedAt   *time.Time  `json:"-"`
+	Expiry       *time.Time  `json:"expiry,omitempty"`
+	Scope        string      `json:"-"`
+	UserAgent    string      `json:"-"`
+	ClientIP     string      `json:"-"`
+	Name         string      `json:"-"`
+	Prefix       string      `json:"-"`
+	Permissions  Permissions `json:"-"`
+	Family       string      `json:"-"`
+	ClientID     int64       `json:"-"`
+	ActorID      int64       `json:"-"`
+	AccessID     string      `json:"-"`
+	AccessExpiry *time.Time  `json:"-"`
+}
+
+// TokenInfo type describes a stored token along with the metadata recorded for it, for
+// listing a user's API keys and exporting their data. The plaintext is only included
+// when the token has just been created.
+type TokenInfo struct {
+	ID          int64       `json:"id"`
+	PlainText   string      `json:"token,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+	UserAgent   string      `json:"user_agent,omitempty"`
+	ClientIP    string      `json:"client_ip,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Prefix      string      `json:"prefix,omitempty"`
+	Permissions Permissions `json:"permissions,omitempty"`
+}
+
+// Session type describes a login session, which is made up of the tokens in a token
+// family. Its ID is the family, which stays the same as the session's tokens are rotated.
+type Session struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Expiry     time.Time `json:"expiry"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+}
+
+// Info() returns the TokenInfo describing the token.
+func (t *Token) Info() *TokenInfo {
+	return &TokenInfo{
+		ID:          t.ID,
+		PlainText:   t.PlainText,
+		CreatedAt:   t.CreatedAt,
+		LastUsedAt:  t.LastUsedAt,
+		Expiry:      t.Expiry,
+		UserAgent:   t.UserAgent,
+		ClientIP:    t.ClientIP,
+		Name:        t.Name,
+		Prefix:      t.Prefix,
+		Permissions: t.Permissions,
+	}
+}
+
+// IsDelegated() returns true for tokens which act with a subset of the permissions of
+// their owner, rather than on behalf of the owner themselves.
+func (t *Token) IsDelegated() bool {
+	return t.Scope == ScopeAPIKey || t.Scope == ScopeOAuthAccess
 }
 
+// generateToken() creates a token for the user. A timeToLive of zero creates a token
+// that never expires.
 func generateToken(userID int64, timeToLive time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
-		Expiry: time.Now().Add(timeToLive),
 		Scope:  scope,
 	}
 
+	if timeToLive > 0 {
+		expiry := time.Now().Add(timeToLive)
+		token.Expiry = &expiry
+	}
+
 	randomBytes := make([]byte, 16)
 
 	// Use the Read() function from the crypto/rand package to fill the byte slice with
@@ -56,13 +148,48 @@ func generateToken(userID int64, timeToLive time.Duration, scope string) (*Token
 	// that we store in the `hash` field of our database table. Note that the
 	// sha256.Sum256() function returns an *array* of length 32, so to make it easier to
 	// work with we convert it to a slice using the [:] operator before storing it.
-	hash := sha256.Sum256([]byte(token.PlainText))
-	token.Hash = hash[:]
+	token.Hash = HashPlainTextToken(token.PlainText)
 
 	return token, nil
 
 }
 
+// NewTokenFamily() returns a random identifier for a new family of session tokens. An
+// authentication token and the refresh tokens that it is rotated through all share the
+// same family, so that they can be revoked together.
+func NewTokenFamily() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// HashPlainTextToken() returns the SHA-256 hash of a plaintext token as a slice, which
+// is the form in which tokens are stored and looked up in the tokens table.
+func HashPlainTextToken(plainTextToken string) []byte {
+	hash := sha256.Sum256([]byte(plainTextToken))
+
+	return hash[:]
+}
+
+// ValidateAPIKey() checks the details of an API key requested by its owner.
+func ValidateAPIKey(v *validator.Validator, token *Token) {
+	v.Check(token.Name != "", "name", "must be provided")
+	v.Check(len(token.Name) <= 100, "name", "must not be more than 100 bytes")
+
+	v.Check(token.Permissions != nil, "permissions", "must be provided")
+	v.Check(len(token.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(token.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range token.Permissions {
+		v.Check(IsKnownPermission(code), "permissions", fmt.Sprintf("%q is not a known permission code", code))
+	}
+}
+
 // ValidatePlainTextToken() checks that the plaintext token is provided and is exactly 52 bytes long
 func ValidatePlainTextToken(v *validator.Validator, plainTextToken string) {
 	v.Check(plainTextToken != "", "token", "must be provided")
@@ -84,32 +211,573 @@ func (m TokenModel) New(userID int64, timeToLive time.Duration, scope string) (*
 	return token, err
 }
 
+// NewSession() creates an authentication token within a token family, and records the
+// user agent and IP address of the client it was issued to, so that it can be listed as
+// part of a session.
+func (m TokenModel) NewSession(userID int64, timeToLive time.Duration, scope, family, userAgent, clientIP string) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+	token.UserAgent = userAgent
+	token.ClientIP = clientIP
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// NewRefresh() creates a refresh token within a token family, like NewSession(). When
+// the authentication token issued alongside it is a signed token, its ID and expiry are
+// recorded too, so that the signed token can be revoked along with the refresh token.
+func (m TokenModel) NewRefresh(userID int64, timeToLive time.Duration, family, userAgent, clientIP, accessID string, accessExpiry *time.Time) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+	token.UserAgent = userAgent
+	token.ClientIP = clientIP
+	token.AccessID = accessID
+	token.AccessExpiry = accessExpiry
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// NewAPIKey() creates a long-lived, named API key for a user which is restricted to the
+// given permissions. The first characters of the key are kept as its prefix, so that
+// the owner can later tell their keys apart.
+func (m TokenModel) NewAPIKey(userID int64, timeToLive time.Duration, name string, permissions Permissions) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, ScopeAPIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Name = name
+	token.Prefix = token.PlainText[:8]
+	token.Permissions = permissions
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// NewOAuthAccess() creates an access token issued to an OAuth client, which acts for the
+// user with the given permissions only.
+func (m TokenModel) NewOAuthAccess(userID, clientID int64, timeToLive time.Duration, permissions Permissions) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, ScopeOAuthAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientID = clientID
+	token.Permissions = permissions
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// NewImpersonation() creates a token which lets the actor act as the user, for support
+// staff who need to see what the user sees.
+func (m TokenModel) NewImpersonation(userID, actorID int64, timeToLive time.Duration) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, ScopeImpersonation)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ActorID = actorID
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-			INSERT INTO tokens (hash, user_id, expiry, scope)
-			VALUES ($1, $2, $3, $4)`
+			INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, client_ip, name, prefix, permissions, family, client_id, actor_id, access_id, access_expiry)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, 0), NULLIF($12, 0), $13, $14)
+			RETURNING id, created_at`
+
+	args := []interface{}{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.UserAgent,
+		token.ClientIP,
+		token.Name,
+		token.Prefix,
+		pq.Array([]string(token.Permissions)),
+		token.Family,
+		token.ClientID,
+		token.ActorID,
+		token.AccessID,
+		token.AccessExpiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// Touch() records that a token has just been used by the client described by the
+// token's UserAgent and ClientIP fields, and reads the remaining session details of the
+// token back into the struct. So that busy clients don't cause a write on every request,
+// the use is only recorded if the token hasn't been used within the last minute.
+func (m TokenModel) Touch(token *Token) error {
+	query := `
+			WITH touched AS (
+				UPDATE tokens
+				SET last_used_at = NOW(), user_agent = $2, client_ip = $3
+				WHERE hash = $1
+				AND (last_used_at IS NULL OR last_used_at < $4)
+			)
+			SELECT id, created_at, last_used_at, expiry, scope, name, prefix, permissions, family, COALESCE(client_id, 0), COALESCE(actor_id, 0)
+			FROM tokens
+			WHERE hash = $1`
+
+	args := []interface{}{token.Hash, token.UserAgent, token.ClientIP, time.Now().Add(-time.Minute)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.ID,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.Expiry,
+		&token.Scope,
+		&token.Name,
+		&token.Prefix,
+		pq.Array((*[]string)(&token.Permissions)),
+		&token.Family,
+		&token.ClientID,
+		&token.ActorID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rotate() marks an unexpired refresh token, identified by the token's Hash field, as
+// used and reads its owner and family back into the struct. Rotated tokens are kept
+// until they expire, so that if one is presented again its whole family is revoked and
+// an ErrTokenReused error is returned.
+func (m TokenModel) Rotate(token *Token) error {
+	query := `
+			UPDATE tokens
+			SET rotated_at = NOW()
+			WHERE hash = $1
+			AND scope = $2
+			AND rotated_at IS NULL
+			AND expiry > $3
+			RETURNING user_id, family`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, token.Hash, ScopeRefresh, time.Now()).Scan(&token.UserID, &token.Family)
+	if err == nil {
+		return nil
+	}
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// The token couldn't be rotated, so check whether that's because it had already
+	// been used.
+	query = `
+			SELECT family
+			FROM tokens
+			WHERE hash = $1
+			AND scope = $2
+			AND rotated_at IS NOT NULL`
+
+	err = m.DB.QueryRowContext(ctx, query, token.Hash, ScopeRefresh).Scan(&token.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = m.DeleteFamily(token.Family)
+	if err != nil {
+		return err
+	}
+
+	return ErrTokenReused
+}
+
+// DeleteFamily() removes every token that belongs to a token family, and adds the
+// signed tokens issued within the family to the revocation denylist.
+func (m TokenModel) DeleteFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+
+	query := `
+			WITH deleted AS (
+				DELETE FROM tokens
+				WHERE family = $1
+				RETURNING access_id, access_expiry
+			)
+			INSERT INTO revoked_tokens (id, expiry)
+			SELECT access_id, access_expiry
+			FROM deleted
+			WHERE access_id <> ''
+			AND access_expiry > NOW()
+			ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, query, family)
 
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(userID int64, scope string) error {
+// GetAllForUser() returns the unexpired tokens with the given scope that belong to a
+// specific user, most recently created first. Plaintext values are never stored, so
+// they are not included.
+func (m TokenModel) GetAllForUser(userID int64, scope string) ([]*TokenInfo, error) {
 	query := `
-			DELETE FROM tokens
+			SELECT id, created_at, last_used_at, expiry, user_agent, client_ip, name, prefix, permissions
+			FROM tokens
 			WHERE user_id = $1
-			AND scope = $2`
+			AND scope = $2
+			AND (expiry IS NULL OR expiry > $3)
+			ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, scope, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*TokenInfo{}
+
+	for rows.Next() {
+		var token TokenInfo
+
+		err := rows.Scan(
+			&token.ID,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.Expiry,
+			&token.UserAgent,
+			&token.ClientIP,
+			&token.Name,
+			&token.Prefix,
+			pq.Array((*[]string)(&token.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetSessionsForUser() returns the sessions of a specific user, most recently used
+// first. A session lasts for as long as it has an unused, unexpired refresh token, and
+// its device details are taken from its most recently used token.
+func (m TokenModel) GetSessionsForUser(userID int64) ([]*Session, error) {
+	query := `
+			SELECT family, MIN(created_at), MAX(COALESCE(last_used_at, created_at)),
+			MAX(expiry) FILTER (WHERE scope = $3 AND rotated_at IS NULL),
+			(ARRAY_AGG(user_agent ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC))[1],
+			(ARRAY_AGG(client_ip ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC))[1]
+			FROM tokens
+			WHERE user_id = $1
+			AND scope = ANY($2)
+			AND family <> ''
+			GROUP BY family
+			HAVING BOOL_OR(scope = $3 AND rotated_at IS NULL AND expiry > $4)
+			ORDER BY 3 DESC, family`
+
+	args := []interface{}{userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), ScopeRefresh, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.ClientIP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSessionForUser() ends a session by removing every token in its family, provided
+// that the session belongs to the specified user. The signed tokens issued within the
+// session are added to the revocation denylist.
+func (m TokenModel) DeleteSessionForUser(id string, userID int64) error {
+	if id == "" {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			WITH deleted AS (
+				DELETE FROM tokens
+				WHERE family = $1
+				AND user_id = $2
+				RETURNING access_id, access_expiry
+			), revoked AS (
+				INSERT INTO revoked_tokens (id, expiry)
+				SELECT access_id, access_expiry
+				FROM deleted
+				WHERE access_id <> ''
+				AND access_expiry > NOW()
+				ON CONFLICT DO NOTHING
+			)
+			SELECT COUNT(*)
+			FROM deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rowsAffected int
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&rowsAffected)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteForUser() removes the token with the given ID and scope, provided that it
+// belongs to the specified user, along with any other tokens in its family.
+func (m TokenModel) DeleteForUser(id, userID int64, scope string) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			WITH target AS (
+				SELECT id, family
+				FROM tokens
+				WHERE id = $1
+				AND user_id = $2
+				AND scope = $3
+			)
+			DELETE FROM tokens
+			USING target
+			WHERE tokens.id = target.id
+			OR (target.family <> '' AND tokens.family = target.family)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser() removes every token of the given scopes that belongs to a user. The
+// signed tokens issued alongside any refresh tokens that are removed are added to the
+// revocation denylist, so that they stop working at the same time.
+func (m TokenModel) DeleteAllForUser(userID int64, scopes ...string) error {
+	query := `
+			WITH deleted AS (
+				DELETE FROM tokens
+				WHERE user_id = $1
+				AND scope = ANY($2)
+				RETURNING access_id, access_expiry
+			)
+			INSERT INTO revoked_tokens (id, expiry)
+			SELECT access_id, access_expiry
+			FROM deleted
+			WHERE access_id <> ''
+			AND access_expiry > NOW()
+			ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(scopes))
+
+	return err
+}
+
+// revokeAllTokensQuery removes every token that belongs to a user, whatever its scope,
+// along with the tokens they were issued while impersonating someone else. The signed
+// tokens issued alongside the user's refresh tokens are added to the denylist as the
+// refresh tokens are removed.
+const revokeAllTokensQuery = `
+			WITH deleted AS (
+				DELETE FROM tokens
+				WHERE user_id = $1
+				OR actor_id = $1
+				RETURNING access_id, access_expiry
+			)
+			INSERT INTO revoked_tokens (id, expiry)
+			SELECT access_id, access_expiry
+			FROM deleted
+			WHERE access_id <> ''
+			AND access_expiry > NOW()
+			ON CONFLICT DO NOTHING`
+
+// RevokeAllForUser() removes every token of a user, including their API keys, the
+// access tokens issued to OAuth clients on their behalf and the invitations they have
+// sent, and revokes their signed authentication tokens. It is used whenever a user is
+// locked out of their account.
+func (m TokenModel) RevokeAllForUser(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, revokeAllTokensQuery, userID)
+
+	return err
+}
+
+// DeleteByHash() removes a single token, identified by its hash, from the tokens table.
+func (m TokenModel) DeleteByHash(hash []byte) error {
+	query := `
+			DELETE FROM tokens
+			WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetOAuthAccess() returns the unexpired OAuth access token with the given hash.
+func (m TokenModel) GetOAuthAccess(hash []byte) (*Token, error) {
+	query := `
+			SELECT id, user_id, client_id, created_at, expiry, permissions
+			FROM tokens
+			WHERE hash = $1
+			AND scope = $2
+			AND expiry > $3`
+
+	token := Token{
+		Hash:  hash,
+		Scope: ScopeOAuthAccess,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash, ScopeOAuthAccess, time.Now()).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.ClientID,
+		&token.CreatedAt,
+		&token.Expiry,
+		pq.Array((*[]string)(&token.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// DeleteOAuthAccess() removes an OAuth access token, provided that it was issued to the
+// given client.
+func (m TokenModel) DeleteOAuthAccess(hash []byte, clientID int64) error {
+	query := `
+			DELETE FROM tokens
+			WHERE hash = $1
+			AND scope = $2
+			AND client_id = $3`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, scope)
+	_, err := m.DB.ExecContext(ctx, query, hash, ScopeOAuthAccess, clientID)
 
 	return err
 }
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/data/datatest"
+)
+
+func TestTokenRotate(t *testing.T) {
+	models := New(datatest.Open(t))
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word-for-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessExpiry := time.Now().Add(time.Hour)
+
+	newRefresh := func(t *testing.T, timeToLive time.Duration, accessID string) *Token {
+		t.Helper()
+
+		family, err := NewTokenFamily()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := models.Tokens.NewRefresh(user.ID, timeToLive, family, "test", "127.0.0.1", accessID, &accessExpiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token
+	}
+
+	t.Run("unused token", func(t *testing.T) {
+		refresh := newRefresh(t, time.Hour, "")
+
+		token := &Token{Hash: refresh.Hash}
+
+		err := models.Tokens.Rotate(token)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if token.UserID != user.ID || token.Family != refresh.Family {
+			t.Errorf("got user %d and family %q, want %d and %q", token.UserID, token.Family, user.ID, refresh.Family)
+		}
+	})
+
+	t.Run("reused token revokes its family", func(t *testing.T) {
+		refresh := newRefresh(t, time.Hour, "reused-access-id")
+		other := newRefresh(t, time.Hour, "other-access-id")
+
+		err := models.Tokens.Rotate(&Token{Hash: refresh.Hash})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The client rotated the token and received a new one in the same family.
+		next, err := models.Tokens.NewRefresh(user.ID, time.Hour, refresh.Family, "test", "127.0.0.1", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = models.Tokens.Rotate(&Token{Hash: refresh.Hash})
+		if !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("got error %v, want %v", err, ErrTokenReused)
+		}
+
+		err = models.Tokens.Rotate(&Token{Hash: next.Hash})
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got error %v rotating the rest of the family, want %v", err, ErrRecordNotFound)
+		}
+
+		revoked, err := models.Revocations.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := revoked["reused-access-id"]; !ok {
+			t.Error("expected the signed token of the family to be revoked")
+		}
+
+		if _, ok := revoked["other-access-id"]; ok {
+			t.Error("expected the signed token of another family to remain valid")
+		}
+
+		err = models.Tokens.Rotate(&Token{Hash: other.Hash})
+		if err != nil {
+			t.Errorf("unexpected error rotating another family: %v", err)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) *Token
+		wantErr error
+	}{
+		{
+			name: "expired token",
+			token: func(t *testing.T) *Token {
+				return &Token{Hash: newRefresh(t, -time.Minute, "").Hash}
+			},
+			wantErr: ErrRecordNotFound,
+		},
+		{
+			name: "unknown token",
+			token: func(t *testing.T) *Token {
+				return &Token{Hash: HashPlainTextToken("ABCDEFGHIJKLMNOPQRSTUVWXYZ")}
+			},
+			wantErr: ErrRecordNotFound,
+		},
+		{
+			name: "token of another scope",
+			token: func(t *testing.T) *Token {
+				family, err := NewTokenFamily()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				token, err := models.Tokens.NewSession(user.ID, time.Hour, ScopeAuthentication, family, "test", "127.0.0.1")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return &Token{Hash: token.Hash}
+			},
+			wantErr: ErrRecordNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := models.Tokens.Rotate(tt.token(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenJSON(t *testing.T) {
+	expiry := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	token := &Token{
+		ID:        42,
+		PlainText: "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		CreatedAt: expiry.Add(-time.Hour),
+		Expiry:    &expiry,
+		UserAgent: "test",
+		ClientIP:  "127.0.0.1",
+		Name:      "key",
+	}
+
+	js, err := json.Marshal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Issuing a token mustn't reveal the metadata recorded for it.
+	want := `{"token":"ABCDEFGHIJKLMNOPQRSTUVWXYZ","expiry":"2024-01-01T12:00:00Z"}`
+
+	if string(js) != want {
+		t.Errorf("got %s, want %s", js, want)
+	}
+}
@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/validator"
+)
+
+// TwoFactor type holds the TOTP enrolment of a user. Enrolment is pending until the
+// user proves that their authenticator app works by confirming a code.
+type TwoFactor struct {
+	UserID       int64
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// GenerateRecoveryCodes() returns n random single-use recovery codes, which let a user
+// sign in when they no longer have access to their authenticator app.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	}
+
+	return codes, nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// ValidateTwoFactorInput() checks that exactly one of a code from an authenticator app
+// or a recovery code was provided.
+func ValidateTwoFactorInput(v *validator.Validator, code, recoveryCode string) {
+	v.Check(code != "" || recoveryCode != "", "code", "must be provided")
+	v.Check(code == "" || recoveryCode == "", "recovery_code", "must not be provided along with a code")
+
+	if code != "" {
+		ValidateTOTPCode(v, code)
+	}
+
+	if recoveryCode != "" {
+		v.Check(len(recoveryCode) == 16, "recovery_code", "must be 16 bytes long")
+	}
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// SetPending() starts a new enrolment for the user with the given secret, replacing any
+// earlier enrolment that was never confirmed.
+func (m TwoFactorModel) SetPending(userID int64, secret []byte) error {
+	query := `
+			INSERT INTO users_totp (user_id, secret)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0
+			WHERE users_totp.confirmed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, secret)
+
+	return err
+}
+
+// Get() returns the enrolment of a user, or an ErrRecordNotFound error if they haven't
+// started one.
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+			SELECT user_id, secret, confirmed_at IS NOT NULL, last_used_step
+			FROM users_totp
+			WHERE user_id = $1`
+
+	var twoFactor TwoFactor
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.Secret,
+		&twoFactor.Confirmed,
+		&twoFactor.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &twoFactor, nil
+}
+
+// IsEnabled() returns true if the user has a confirmed enrolment.
+func (m TwoFactorModel) IsEnabled(userID int64) (bool, error) {
+	query := `
+			SELECT EXISTS(SELECT 1 FROM users_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+
+	return enabled, err
+}
+
+// Enable() confirms the pending enrolment of a user and replaces their recovery codes,
+// storing only the hashes of the codes.
+func (m TwoFactorModel) Enable(userID int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+			UPDATE users_totp
+			SET confirmed_at = NOW()
+			WHERE user_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+			DELETE FROM recovery_codes
+			WHERE user_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO recovery_codes (user_id, hash)
+			VALUES ($1, $2)`
+
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, query, userID, HashPlainTextToken(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Disable() removes the enrolment and the recovery codes of a user.
+func (m TwoFactorModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep() records that the code for a time step has been used, returning false if a
+// code for that step (or a later one) has been used already, which stops an observed
+// code from being replayed.
+func (m TwoFactorModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+			UPDATE users_totp
+			SET last_used_step = $2
+			WHERE user_id = $1
+			AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode() consumes one of the user's recovery codes, returning false if the
+// code doesn't match any that remain.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+			DELETE FROM recovery_codes
+			WHERE user_id = $1
+			AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, HashPlainTextToken(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
@@ -2,32 +2,41 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/mycok/sunrise-api/internal/validator"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID               int64      `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Password         password   `json:"-"`
+	Activated        bool       `json:"activated"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+	Version          int        `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// IsSuspended() returns true if an administrator has locked the user out of their
+// account. Unlike an account that hasn't been activated, a suspended account can't be
+// used at all.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRegex), "email", "must be a valid email")
@@ -36,7 +45,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePassword(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be atleast 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= 256, "password", "must not be more than 256 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -47,6 +56,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	if user.Password.plainText != nil {
 		ValidatePassword(v, *user.Password.plainText)
+		ValidatePasswordPolicy(v, *user.Password.plainText, user)
 	}
 
 	// If the password hash is ever nil, this will be due to a logic error in our
@@ -64,10 +74,10 @@ type password struct {
 	hash      []byte
 }
 
-// The Set() method calculates the bcrypt hash of a plaintext password, and stores both
-// the hash and the plaintext versions in the struct.
+// The Set() method calculates the hash of a plaintext password with the configured
+// PasswordHasher, and stores both the hash and the plaintext versions in the struct.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := PasswordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -80,19 +90,21 @@ func (p *password) Set(plaintextPassword string) error {
 
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
-// otherwise.
+// otherwise. The hash is checked with the algorithm that produced it.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
 
-	return true, nil
+	return hasher.Matches(plaintextPassword, p.hash)
+}
+
+// The NeedsRehash() method returns true if the stored hash wasn't produced by the
+// configured PasswordHasher with its current parameters, including when it was produced
+// by a different algorithm.
+func (p *password) NeedsRehash() bool {
+	return PasswordHasher.NeedsRehash(p.hash)
 }
 
 type UserModel struct {
@@ -131,12 +143,150 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// insertUser() adds a user in the same way as Insert(), as part of the given
+// transaction.
+func insertUser(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `
+			INSERT INTO users (name, email, password_hash, activated)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, version`
+
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get retrieves the details of a specific user based on their ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, created_at, name, email, password_hash, activated, suspended_at, suspension_reason, version
+			FROM users
+			WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// List() returns the users that match the given filters, along with pagination
+// metadata. The email filter matches any part of an address, and the permission filter
+// matches users who hold the permission either directly or through one of their roles,
+// including through a wildcard permission that covers it, the same way as Include().
+func (m UserModel) List(email string, activated *bool, permission string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, name, email, activated, suspended_at, suspension_reason, version
+		FROM users
+		WHERE (strpos(email, $1) > 0 OR $1 = '')
+		AND (activated = $2 OR $2::boolean IS NULL)
+		AND (id IN (
+			SELECT users_permissions.user_id
+			FROM users_permissions
+			INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+			WHERE permissions.code = $3
+			OR (right(permissions.code, 1) = '*' AND left($3, length(permissions.code) - 1) = left(permissions.code, -1))
+			UNION
+			SELECT users_roles.user_id
+			FROM users_roles
+			INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+			INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+			WHERE permissions.code = $3
+			OR (right(permissions.code, 1) = '*' AND left($3, length(permissions.code) - 1) = left(permissions.code, -1))
+		) OR $3 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $4
+		OFFSET $5`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{email, activated, permission, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.SuspendedAt,
+			&user.SuspensionReason,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
 // GetByEmail retrieves user details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-			SELECT id, created_at, name, email, password_hash, activated, version
+			SELECT id, created_at, name, email, password_hash, activated, suspended_at, suspension_reason, version
 			FROM users
 			WHERE email = $1`
 
@@ -152,6 +302,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
 		&user.Version,
 	)
 
@@ -204,21 +356,22 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(plainTextToken, scope string) (*User, error) {
+// GetForToken() returns the user that an unexpired token belongs to, provided that the
+// token has one of the given scopes.
+func (m UserModel) GetForToken(plainTextToken string, scopes ...string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
-	tokenHash := sha256.Sum256([]byte(plainTextToken))
+	tokenHash := HashPlainTextToken(plainTextToken)
 
 	query := `
-			SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+			SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.suspended_at, users.suspension_reason, users.version
 			FROM users
 			INNER JOIN tokens
 			ON users.id = tokens.user_id
 			WHERE tokens.hash = $1
-			AND tokens.scope = $2
-			AND tokens.expiry > $3`
+			AND tokens.scope = ANY($2)
+			AND (tokens.expiry IS NULL OR tokens.expiry > $3)`
 
-	// Use [:] to convert the tokenHash array into a slice
-	args := []interface{}{tokenHash[:], scope, time.Now()}
+	args := []interface{}{tokenHash, pq.Array(scopes), time.Now()}
 
 	var user User
 
@@ -232,6 +385,8 @@ func (m UserModel) GetForToken(plainTextToken, scope string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
 		&user.Version,
 	)
 	if err != nil {
@@ -246,3 +401,248 @@ func (m UserModel) GetForToken(plainTextToken, scope string) (*User, error) {
 
 	return &user, nil
 }
+
+// SetPendingEmail() records an email address that the user has asked to change to, but
+// has not yet confirmed ownership of.
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+			UPDATE users
+			SET pending_email = $1
+			WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, userID)
+
+	return err
+}
+
+// GetPendingEmail() returns the email address that the user has asked to change to, or
+// an empty string if they haven't asked to change it.
+func (m UserModel) GetPendingEmail(userID int64) (string, error) {
+	query := `
+			SELECT COALESCE(pending_email, '')
+			FROM users
+			WHERE id = $1`
+
+	var email string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
+// ConfirmEmailChange() replaces the user's email address with their pending one. As with
+// Update(), the version field is checked to help prevent any race conditions.
+func (m UserModel) ConfirmEmailChange(user *User) error {
+	query := `
+			UPDATE users
+			SET email = pending_email, pending_email = NULL, version = version + 1
+			WHERE id = $1
+			AND version = $2
+			AND pending_email IS NOT NULL
+			RETURNING email, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Email, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Suspend() locks a user out of their account for the given reason. Suspending a user
+// who is already suspended updates the reason, but keeps the original suspension time.
+func (m UserModel) Suspend(user *User, reason string) error {
+	query := `
+			UPDATE users
+			SET suspended_at = COALESCE(suspended_at, NOW()), suspension_reason = $1, version = version + 1
+			WHERE id = $2
+			AND version = $3
+			RETURNING suspended_at, suspension_reason, version`
+
+	args := []interface{}{reason, user.ID, user.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.SuspendedAt, &user.SuspensionReason, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unsuspend() lifts the suspension of a user.
+func (m UserModel) Unsuspend(user *User) error {
+	query := `
+			UPDATE users
+			SET suspended_at = NULL, suspension_reason = '', version = version + 1
+			WHERE id = $1
+			AND version = $2
+			RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.SuspendedAt = nil
+	user.SuspensionReason = ""
+
+	return nil
+}
+
+// Delete() removes a specific user. Their tokens are revoked first, so that the signed
+// tokens issued to them are denylisted, and their permissions and everything else held
+// about them are removed along with them by the ON DELETE CASCADE constraints on the
+// related tables.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, revokeAllTokensQuery, id)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			DELETE FROM users
+			WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Erase() anonymizes a user in place of deleting them. Their name and email address are
+// scrubbed and their password is replaced with a random one, and everything else held
+// about them is removed. The row itself is kept, so that anything which refers to the
+// user keeps referring to a valid record.
+func (m UserModel) Erase(id int64) error {
+	password, err := generateToken(id, 0, "")
+	if err != nil {
+		return err
+	}
+
+	hash, err := PasswordHasher.Hash(password.PlainText)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Login attempts are recorded by email address, so they are removed before the
+	// address is scrubbed. Tokens are revoked in the same way as when the user is
+	// locked out of their account.
+	queries := []string{
+		`DELETE FROM login_attempts WHERE email = (SELECT email FROM users WHERE id = $1)`,
+		revokeAllTokensQuery,
+		`DELETE FROM oauth_clients WHERE user_id = $1`,
+		`DELETE FROM oauth_codes WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM users_totp WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM users_permissions WHERE user_id = $1`,
+		`DELETE FROM users_roles WHERE user_id = $1`,
+		`DELETE FROM data_exports WHERE user_id = $1`,
+		`DELETE FROM user_certificates WHERE user_id = $1`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+			UPDATE users
+			SET name = 'Erased user', email = 'erased-' || id || '@erased.invalid', pending_email = NULL,
+			password_hash = $2, activated = false, suspended_at = NULL, suspension_reason = '',
+			version = version + 1
+			WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
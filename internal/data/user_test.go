@@ -0,0 +1,88 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mycok/sunrise-api/internal/data/datatest"
+)
+
+func TestUserListPermissionFilter(t *testing.T) {
+	models := New(datatest.Open(t))
+
+	newUser := func(t *testing.T, name string) *User {
+		t.Helper()
+
+		user := &User{Name: name, Email: name + "@example.com", Activated: true}
+
+		err := user.Password.Set("pa55word-for-" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = models.Users.Insert(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return user
+	}
+
+	// A wildcard granted directly, a role, a role with the global wildcard, and another
+	// resource granted directly.
+	alice := newUser(t, "alice")
+	bob := newUser(t, "bob")
+	carol := newUser(t, "carol")
+	dave := newUser(t, "dave")
+
+	err := models.Permissions.AddForUser(alice.ID, "movies:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Roles.AddForUser(bob.ID, "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Roles.AddForUser(carol.ID, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Permissions.AddForUser(dave.ID, "users:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		permission string
+		want       []int64
+	}{
+		{"", []int64{alice.ID, bob.ID, carol.ID, dave.ID}},
+		{"movies:read", []int64{alice.ID, bob.ID, carol.ID}},
+		{"movies:write", []int64{alice.ID, carol.ID}},
+		{"users:admin", []int64{carol.ID, dave.ID}},
+		{"metrics:view", []int64{carol.ID}},
+	}
+
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	for _, tt := range tests {
+		t.Run(tt.permission, func(t *testing.T) {
+			users, _, err := models.Users.List("", nil, tt.permission, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []int64{}
+			for _, user := range users {
+				got = append(got, user.ID)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got users %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms, named as they appear in the "alg" header of a token.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+var encoding = base64.RawURLEncoding
+
+// RegisteredClaims holds the registered claims that are read and checked by this
+// package. Application specific claims are added by embedding it in another struct.
+type RegisteredClaims struct {
+	ID        string `json:"jti,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// NewID() returns a random identifier suitable for the "jti" claim.
+func NewID() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key type holds the material for a single signing key, identified by its key ID.
+type Key struct {
+	ID           string
+	Algorithm    string
+	secret       []byte
+	privateKey   ed25519.PrivateKey
+	publicKey    ed25519.PublicKey
+	rsaPublicKey *rsa.PublicKey
+}
+
+// NewRSAPublicKey() returns a key which verifies RS256 signatures, such as those on the
+// tokens of an external identity provider. It can't be used to sign tokens.
+func NewRSAPublicKey(id string, publicKey *rsa.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: AlgorithmRS256, rsaPublicKey: publicKey}
+}
+
+func (k *Key) sign(signingInput []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+
+		return mac.Sum(nil), nil
+	case AlgorithmEdDSA:
+		if k.privateKey == nil {
+			return nil, fmt.Errorf("jwt: key %q can only be used for verification", k.ID)
+		}
+
+		return k.privateKey.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	case AlgorithmRS256:
+		return nil, fmt.Errorf("jwt: key %q can only be used for verification", k.ID)
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", k.Algorithm)
+	}
+}
+
+func (k *Key) verify(signingInput, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+
+		return hmac.Equal(signature, mac.Sum(nil))
+	case AlgorithmEdDSA:
+		return ed25519.Verify(k.publicKey, signingInput, signature)
+	case AlgorithmRS256:
+		digest := sha256.Sum256(signingInput)
+
+		return rsa.VerifyPKCS1v15(k.rsaPublicKey, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// KeySet type holds every key that tokens may be verified with, along with the active
+// key that new tokens are signed with. Keeping retired keys in the set lets tokens that
+// were signed before a key rotation remain valid until they expire.
+type KeySet struct {
+	keys   map[string]*Key
+	signer *Key
+}
+
+// NewKeySet() returns a key set holding the given keys, which can only be used to verify
+// tokens.
+func NewKeySet(keys ...*Key) *KeySet {
+	ks := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		ks.keys[key.ID] = key
+	}
+
+	return ks
+}
+
+// LoadKeySet() reads every key in a directory, using each file name (without its
+// extension) as the key ID. Files ending in ".key" hold a raw HS256 secret of at least
+// 32 bytes, and files ending in ".pem" hold a PEM encoded Ed25519 private key, or a
+// public key for keys that have been retired from signing. The key identified by
+// activeKeyID is used to sign new tokens.
+func LoadKeySet(dir, activeKeyID string) (*KeySet, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{keys: make(map[string]*Key)}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(file.Name())
+		if ext != ".key" && ext != ".pem" {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		key := &Key{ID: strings.TrimSuffix(file.Name(), ext)}
+
+		switch ext {
+		case ".key":
+			key.Algorithm = AlgorithmHS256
+			key.secret = contents
+
+			if len(key.secret) < 32 {
+				return nil, fmt.Errorf("jwt: secret %q must be at least 32 bytes long", file.Name())
+			}
+		case ".pem":
+			key.Algorithm = AlgorithmEdDSA
+
+			err = parseEd25519PEM(key, contents)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: %s: %w", file.Name(), err)
+			}
+		}
+
+		ks.keys[key.ID] = key
+	}
+
+	signer, ok := ks.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: active key %q not found in %s", activeKeyID, dir)
+	}
+
+	if signer.Algorithm == AlgorithmEdDSA && signer.privateKey == nil {
+		return nil, fmt.Errorf("jwt: active key %q must be a private key", activeKeyID)
+	}
+
+	ks.signer = signer
+
+	return ks, nil
+}
+
+func parseEd25519PEM(key *Key, contents []byte) error {
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+
+		privateKey, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return errors.New("not an Ed25519 private key")
+		}
+
+		key.privateKey = privateKey
+		key.publicKey = privateKey.Public().(ed25519.PublicKey)
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+
+		publicKey, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("not an Ed25519 public key")
+		}
+
+		key.publicKey = publicKey
+	default:
+		return fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	return nil
+}
+
+// Sign() encodes the claims as the payload of a new token, signed with the active key.
+func (ks *KeySet) Sign(claims interface{}) (string, error) {
+	if ks.signer == nil {
+		return "", errors.New("jwt: key set has no signing key")
+	}
+
+	h, err := json.Marshal(header{Algorithm: ks.signer.Algorithm, Type: "JWT", KeyID: ks.signer.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+
+	signature, err := ks.signer.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify() checks the signature and expiry of a token, then decodes its payload into
+// claims. The key is chosen by the "kid" header, and the "alg" header must match the
+// algorithm of that key.
+func (ks *KeySet) Verify(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	var h header
+
+	err = json.Unmarshal(headerJSON, &h)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	if h.Algorithm != key.Algorithm {
+		return ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	var registered RegisteredClaims
+
+	err = json.Unmarshal(payload, &registered)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if registered.ExpiresAt != 0 && time.Now().Unix() >= registered.ExpiresAt {
+		return ErrExpiredToken
+	}
+
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	RegisteredClaims
+	Name string `json:"name"`
+}
+
+func newHS256Key(id string) *Key {
+	return &Key{ID: id, Algorithm: AlgorithmHS256, secret: []byte(strings.Repeat(id, 32))}
+}
+
+func newEdDSAKey(t *testing.T, id string) *Key {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Key{ID: id, Algorithm: AlgorithmEdDSA, privateKey: privateKey, publicKey: publicKey}
+}
+
+func newSigningKeySet(signer *Key, others ...*Key) *KeySet {
+	ks := NewKeySet(append(others, signer)...)
+	ks.signer = signer
+
+	return ks
+}
+
+// token() builds a token from raw parts, so that tests can craft headers that Sign()
+// would never produce.
+func token(t *testing.T, h header, claims interface{}, signature []byte) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(payload) + "." + encoding.EncodeToString(signature)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		key  *Key
+	}{
+		{"HS256", newHS256Key("a")},
+		{"EdDSA", newEdDSAKey(t, "b")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := newSigningKeySet(tt.key)
+
+			claims := testClaims{
+				RegisteredClaims: RegisteredClaims{ID: "id", Subject: "42", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+				Name:             "alice",
+			}
+
+			signed, err := ks.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got testClaims
+
+			err = ks.Verify(signed, &got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != claims {
+				t.Errorf("got claims %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	hs256 := newHS256Key("current")
+	ks := newSigningKeySet(hs256)
+
+	valid, err := ks.Sign(testClaims{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := ks.Sign(RegisteredClaims{ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown, err := newSigningKeySet(newHS256Key("unknown")).Sign(testClaims{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+
+	tamperedPayload, err := json.Marshal(testClaims{Name: "mallory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", valid, nil},
+		{"expired", expired, ErrExpiredToken},
+		{"unknown key", unknown, ErrUnknownKey},
+		{"tampered payload", parts[0] + "." + encoding.EncodeToString(tamperedPayload) + "." + parts[2], ErrInvalidToken},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + encoding.EncodeToString([]byte("signature")), ErrInvalidToken},
+		{"missing signature", parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"algorithm none", token(t, header{Algorithm: "none", KeyID: "current"}, testClaims{Name: "mallory"}, nil), ErrInvalidToken},
+		{"algorithm mismatch", token(t, header{Algorithm: AlgorithmEdDSA, KeyID: "current"}, testClaims{Name: "mallory"}, nil), ErrInvalidToken},
+		{"too few parts", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"too many parts", valid + ".extra", ErrInvalidToken},
+		{"invalid header", "not-base64!." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims testClaims
+
+			err := ks.Verify(tt.token, &claims)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	retired := newHS256Key("2023")
+	current := newEdDSAKey(t, "2024")
+
+	before, err := newSigningKeySet(retired).Sign(testClaims{Name: "before"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the rotation, new tokens are signed with the current key while tokens signed
+	// with the retired key can still be verified.
+	ks := newSigningKeySet(current, retired)
+
+	after, err := ks.Sign(testClaims{Name: "after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signed := range []string{before, after} {
+		var claims testClaims
+
+		err = ks.Verify(signed, &claims)
+		if err != nil {
+			t.Errorf("unexpected error verifying %q: %v", claims.Name, err)
+		}
+	}
+
+	var h header
+
+	headerJSON, err := encoding.DecodeString(strings.Split(after, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(headerJSON, &h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.KeyID != "2024" || h.Algorithm != AlgorithmEdDSA {
+		t.Errorf("got header %+v, want the current key", h)
+	}
+
+	// Once the retired key is removed, its tokens are rejected.
+	var claims testClaims
+
+	err = newSigningKeySet(current).Verify(before, &claims)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestRS256VerifyOnly(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := newSigningKeySet(NewRSAPublicKey("rsa", &privateKey.PublicKey))
+
+	_, err = ks.Sign(testClaims{Name: "alice"})
+	if err == nil {
+		t.Error("expected an error signing with an RSA public key")
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"hmac.key":    []byte(strings.Repeat("s", 32)),
+		"short.key":   []byte("too short"),
+		"private.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		"public.pem":  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		"invalid.pem": []byte("not a PEM file"),
+		"README.md":   []byte("ignored"),
+	}
+
+	tests := []struct {
+		name        string
+		files       []string
+		activeKeyID string
+		wantErr     bool
+	}{
+		{"HS256 signer", []string{"hmac.key", "README.md"}, "hmac", false},
+		{"EdDSA signer", []string{"private.pem", "hmac.key"}, "private", false},
+		{"retired public key", []string{"private.pem", "public.pem"}, "private", false},
+		{"public key as signer", []string{"public.pem"}, "public", true},
+		{"short secret", []string{"hmac.key", "short.key"}, "hmac", true},
+		{"invalid PEM", []string{"hmac.key", "invalid.pem"}, "hmac", true},
+		{"missing active key", []string{"hmac.key"}, "other", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			for _, name := range tt.files {
+				err := ioutil.WriteFile(filepath.Join(dir, name), files[name], 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ks, err := LoadKeySet(dir, tt.activeKeyID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			signed, err := ks.Sign(testClaims{Name: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims testClaims
+
+			err = ks.Verify(signed, &claims)
+			if err != nil || claims.Name != "alice" {
+				t.Errorf("got claims %+v and error %v", claims, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+// Package oidc implements the relying party side of the OpenID Connect authorization
+// code flow with PKCE, against a provider whose endpoints are found through discovery.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/jwt"
+)
+
+var (
+	// ErrInvalidIDToken is returned when the ID token issued by the provider can't be
+	// verified, or its claims don't match the login it was issued for.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+
+	// ErrExchangeFailed is returned when the provider rejects an authorization code.
+	ErrExchangeFailed = errors.New("authorization code exchange failed")
+)
+
+// jwksRefreshInterval limits how often the keys of the provider are fetched again when a
+// token is signed with a key that isn't known yet.
+const jwksRefreshInterval = time.Minute
+
+var encoding = base64.RawURLEncoding
+
+// Config type holds the settings of the client registered with the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Claims type holds the claims of an ID token that are used to identify the user.
+type Claims struct {
+	jwt.RegisteredClaims
+	Issuer        string   `json:"iss"`
+	Audience      audience `json:"aud"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience holds the "aud" claim, which may be either a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+
+		return nil
+	}
+
+	var multiple []string
+
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Provider type holds the configuration and the discovered endpoints of an OpenID
+// provider, along with its signing keys.
+type Provider struct {
+	config                Config
+	client                *http.Client
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+
+	mu          sync.Mutex
+	keys        *jwt.KeySet
+	keysFetched time.Time
+}
+
+// Discover() reads the configuration document of the issuer and returns a provider that
+// uses its endpoints. All requests to the provider are made with the given HTTP client.
+func Discover(ctx context.Context, client *http.Client, config Config) (*Provider, error) {
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	err := getJSON(ctx, client, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &document)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+
+	// The issuer in the document must match the one it was fetched for exactly, as
+	// required by the discovery specification.
+	if document.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", document.Issuer, config.Issuer)
+	}
+
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: missing endpoints in provider configuration")
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		config:                config,
+		client:                client,
+		authorizationEndpoint: document.AuthorizationEndpoint,
+		tokenEndpoint:         document.TokenEndpoint,
+		jwksURI:               document.JWKSURI,
+	}, nil
+}
+
+// Issuer() returns the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// NewRandom() returns a random URL-safe string, used for the state, nonce and PKCE code
+// verifier of a login.
+func NewRandom() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(randomBytes), nil
+}
+
+// AuthCodeURL() returns the URL of the provider that the user is sent to in order to log
+// in. The code verifier is sent as an S256 challenge and must later be passed to
+// Exchange() along with the authorization code.
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {encoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.authorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange() trades an authorization code for tokens at the token endpoint of the
+// provider, and returns the verified claims of the ID token. The nonce must be the one
+// that was sent with the authorization request.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+
+		return nil, ErrExchangeFailed
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens.IDToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// verifyIDToken() checks the signature of an ID token against the keys of the provider,
+// along with the claims that tie it to this client and login.
+func (p *Provider) verifyIDToken(ctx context.Context, token, nonce string) (*Claims, error) {
+	keys, err := p.keySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+
+	err = keys.Verify(token, &claims)
+
+	// The provider may have rotated its keys since they were last fetched.
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		keys, err = p.keySet(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+
+		err = keys.Verify(token, &claims)
+	}
+
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, ErrInvalidIDToken
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, ErrInvalidIDToken
+	case claims.Nonce != nonce:
+		return nil, ErrInvalidIDToken
+	case claims.Subject == "" || claims.ExpiresAt == 0:
+		return nil, ErrInvalidIDToken
+	}
+
+	return &claims, nil
+}
+
+// keySet() returns the signing keys of the provider, fetching them if they haven't been
+// fetched yet, or if refresh is true and they weren't fetched too recently.
+func (p *Provider) keySet(ctx context.Context, refresh bool) (*jwt.KeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.keysFetched) < jwksRefreshInterval) {
+		return p.keys, nil
+	}
+
+	var document struct {
+		Keys []struct {
+			KeyType   string `json:"kty"`
+			KeyID     string `json:"kid"`
+			Use       string `json:"use"`
+			Algorithm string `json:"alg"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := getJSON(ctx, p.client, p.jwksURI, &document)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching keys: %w", err)
+	}
+
+	var keys []*jwt.Key
+
+	// Only RSA signing keys are supported, so any other keys are skipped.
+	for _, k := range document.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Algorithm != "" && k.Algorithm != jwt.AlgorithmRS256) {
+			continue
+		}
+
+		n, err := encoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := encoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+
+		publicKey := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+		keys = append(keys, jwt.NewRSAPublicKey(k.KeyID, publicKey))
+	}
+
+	p.keys = jwt.NewKeySet(keys...)
+	p.keysFetched = time.Now()
+
+	return p.keys, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, url)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
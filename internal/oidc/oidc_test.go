@@ -0,0 +1,290 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mycok/sunrise-api/internal/oidc/oidctest"
+)
+
+const (
+	testClientID     = "sunrise-api"
+	testClientSecret = "s3cret/with+reserved&chars"
+	testRedirectURL  = "http://localhost:4000/v1/oidc/callback"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Provider, *Provider) {
+	t.Helper()
+
+	idp, err := oidctest.NewProvider(testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(idp.Close)
+
+	provider, err := Discover(context.Background(), idp.Server.Client(), Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return idp, provider
+}
+
+// login() goes through the authorization code flow against the fake provider, with the
+// ID token holding the given claims, and returns the result of the exchange.
+func login(t *testing.T, idp *oidctest.Provider, provider *Provider, claims map[string]interface{}) (*Claims, error) {
+	t.Helper()
+
+	state, nonce, codeVerifier := newLoginSecrets(t)
+
+	code, err := idp.Authorize(provider.AuthCodeURL(state, nonce, codeVerifier), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider.Exchange(context.Background(), code, codeVerifier, nonce)
+}
+
+func newLoginSecrets(t *testing.T) (state, nonce, codeVerifier string) {
+	t.Helper()
+
+	for _, s := range []*string{&state, &nonce, &codeVerifier} {
+		var err error
+
+		*s, err = NewRandom()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return state, nonce, codeVerifier
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp, err := oidctest.NewProvider(testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer idp.Close()
+
+	_, err = Discover(context.Background(), idp.Server.Client(), Config{
+		Issuer:   idp.Issuer() + "/",
+		ClientID: testClientID,
+	})
+	if err == nil {
+		t.Fatal("expected an error for an issuer that doesn't match the discovery document")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	_, provider := newTestProvider(t)
+
+	u, err := url.Parse(provider.AuthCodeURL("state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qs := u.Query()
+
+	tests := []struct {
+		param string
+		want  string
+	}{
+		{"response_type", "code"},
+		{"client_id", testClientID},
+		{"redirect_uri", testRedirectURL},
+		{"scope", "openid email profile"},
+		{"state", "state"},
+		{"nonce", "nonce"},
+		{"code_challenge_method", "S256"},
+		// BASE64URL(SHA256("verifier")), as defined by RFC 7636.
+		{"code_challenge", "iMnq5o6zALKXGivsnlom_0F5_WYda32GHkxlV7mq7hQ"},
+	}
+
+	for _, tt := range tests {
+		if got := qs.Get(tt.param); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.param, got, tt.want)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	claims, err := login(t, idp, provider, map[string]interface{}{
+		"sub":            "user-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Name != "Alice" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestExchangeCodeVerifier(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	tests := []struct {
+		name         string
+		codeVerifier func(codeVerifier string) string
+		wantErr      error
+	}{
+		{"matching verifier", func(v string) string { return v }, nil},
+		{"different verifier", func(v string) string { return v + "x" }, ErrExchangeFailed},
+		{"missing verifier", func(v string) string { return "" }, ErrExchangeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, nonce, codeVerifier := newLoginSecrets(t)
+
+			code, err := idp.Authorize(provider.AuthCodeURL(state, nonce, codeVerifier), map[string]interface{}{"sub": "user-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.Exchange(context.Background(), code, tt.codeVerifier(codeVerifier), nonce)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeReuse(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	state, nonce, codeVerifier := newLoginSecrets(t)
+
+	code, err := idp.Authorize(provider.AuthCodeURL(state, nonce, codeVerifier), map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Exchange(context.Background(), code, codeVerifier, nonce)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = provider.Exchange(context.Background(), code, codeVerifier, nonce)
+	if !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("got error %v, want %v", err, ErrExchangeFailed)
+	}
+}
+
+func TestExchangeInvalidClaims(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"wrong nonce", map[string]interface{}{"sub": "user-1", "nonce": "other-nonce"}},
+		{"missing nonce", map[string]interface{}{"sub": "user-1", "nonce": ""}},
+		{"wrong issuer", map[string]interface{}{"sub": "user-1", "iss": "https://evil.example.com"}},
+		{"wrong audience", map[string]interface{}{"sub": "user-1", "aud": "other-client"}},
+		{"audience array without client", map[string]interface{}{"sub": "user-1", "aud": []string{"a", "b"}}},
+		{"missing subject", map[string]interface{}{}},
+		{"expired", map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := login(t, idp, provider, tt.claims)
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestExchangeAudienceArray(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	_, err := login(t, idp, provider, map[string]interface{}{
+		"sub": "user-1",
+		"aud": []string{"other-client", testClientID},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExchangeKeyRotation(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	_, err := login(t, idp, provider, map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fetches := idp.JWKSFetches(); fetches != 1 {
+		t.Fatalf("got %d key fetches, want 1", fetches)
+	}
+
+	// Logins with a known key use the cached keys.
+	_, err = login(t, idp, provider, map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fetches := idp.JWKSFetches(); fetches != 1 {
+		t.Fatalf("got %d key fetches, want 1", fetches)
+	}
+
+	err = idp.RotateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys were fetched too recently to be fetched again, so a token signed with the
+	// new key is rejected.
+	_, err = login(t, idp, provider, map[string]interface{}{"sub": "user-1"})
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidIDToken)
+	}
+
+	if fetches := idp.JWKSFetches(); fetches != 1 {
+		t.Fatalf("got %d key fetches, want 1", fetches)
+	}
+
+	provider.mu.Lock()
+	provider.keysFetched = time.Now().Add(-jwksRefreshInterval)
+	provider.mu.Unlock()
+
+	// Once the interval has passed, the unknown key causes the keys to be fetched again.
+	_, err = login(t, idp, provider, map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fetches := idp.JWKSFetches(); fetches != 2 {
+		t.Fatalf("got %d key fetches, want 2", fetches)
+	}
+}
+
+func TestExchangeWrongClientSecret(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	provider.config.ClientSecret = "wrong"
+
+	_, err := login(t, idp, provider, map[string]interface{}{"sub": "user-1"})
+	if !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("got error %v, want %v", err, ErrExchangeFailed)
+	}
+}
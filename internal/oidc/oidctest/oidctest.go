@@ -0,0 +1,278 @@
+// Package oidctest provides a fake OpenID provider for tests. It serves discovery, its
+// signing keys and a token endpoint which checks PKCE, and issues RS256 signed ID tokens
+// for the logins that a test authorizes.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var encoding = base64.RawURLEncoding
+
+// Provider type is a fake OpenID provider running on a local test server.
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu          sync.Mutex
+	key         *rsa.PrivateKey
+	keyID       string
+	keyCount    int
+	jwksFetches int
+	logins      map[string]*login
+}
+
+// login holds the details of an authorization request, until its code is exchanged.
+type login struct {
+	redirectURI   string
+	codeChallenge string
+	claims        map[string]interface{}
+}
+
+// NewProvider() starts a fake provider which only accepts the given client.
+func NewProvider(clientID, clientSecret string) (*Provider, error) {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		logins:       make(map[string]*login),
+	}
+
+	err := p.RotateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discoveryHandler)
+	mux.HandleFunc("/jwks", p.jwksHandler)
+	mux.HandleFunc("/token", p.tokenHandler)
+
+	p.Server = httptest.NewServer(mux)
+
+	return p, nil
+}
+
+// Issuer() returns the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Close() shuts down the server of the provider.
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// RotateKey() replaces the signing key of the provider with a new key, which has a new
+// key ID. Only the new key is published from then on.
+func (p *Provider) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keyCount++
+	p.key = key
+	p.keyID = fmt.Sprintf("key-%d", p.keyCount)
+
+	return nil
+}
+
+// JWKSFetches() returns the number of times that the signing keys have been fetched.
+func (p *Provider) JWKSFetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.jwksFetches
+}
+
+// Authorize() logs a user in, as if they had followed an authorization URL made by the
+// relying party and signed in at the provider. It returns the code that the provider
+// would redirect back with. The ID token issued for the code holds the standard claims
+// for the login, along with the given claims, which take precedence over the standard
+// ones so that tests can issue tokens with invalid claims.
+func (p *Provider) Authorize(authorizationURL string, claims map[string]interface{}) (string, error) {
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		return "", err
+	}
+
+	qs := u.Query()
+
+	switch {
+	case qs.Get("response_type") != "code":
+		return "", fmt.Errorf("oidctest: unsupported response type %q", qs.Get("response_type"))
+	case qs.Get("client_id") != p.ClientID:
+		return "", fmt.Errorf("oidctest: unknown client %q", qs.Get("client_id"))
+	case qs.Get("code_challenge_method") != "S256" || qs.Get("code_challenge") == "":
+		return "", fmt.Errorf("oidctest: missing S256 code challenge")
+	}
+
+	now := time.Now()
+
+	tokenClaims := map[string]interface{}{
+		"iss":   p.Issuer(),
+		"aud":   p.ClientID,
+		"nonce": qs.Get("nonce"),
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+
+	for name, value := range claims {
+		tokenClaims[name] = value
+	}
+
+	code, err := newRandom()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logins[code] = &login{
+		redirectURI:   qs.Get("redirect_uri"),
+		codeChallenge: qs.Get("code_challenge"),
+		claims:        tokenClaims,
+	}
+
+	return code, nil
+}
+
+func (p *Provider) discoveryHandler(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.Issuer() + "/authorize",
+		"token_endpoint":         p.Issuer() + "/token",
+		"jwks_uri":               p.Issuer() + "/jwks",
+	})
+}
+
+func (p *Provider) jwksHandler(rw http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.jwksFetches++
+
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": p.keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   encoding.EncodeToString(p.key.N.Bytes()),
+				"e":   encoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// tokenHandler() exchanges an authorization code for an ID token. Each code can only be
+// exchanged once, by the client it was issued to, with the code verifier that matches
+// its challenge.
+func (p *Provider) tokenHandler(rw http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+
+	if !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	login, found := p.logins[r.PostForm.Get("code")]
+	delete(p.logins, r.PostForm.Get("code"))
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+	if !found || login.redirectURI != r.PostForm.Get("redirect_uri") || encoding.EncodeToString(challenge[:]) != login.codeChallenge {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+
+		return
+	}
+
+	idToken, err := p.sign(login.claims)
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]string{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// sign() returns an RS256 signed token holding the claims, signed with the current key.
+// The caller must hold the lock.
+func (p *Provider) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.keyID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+func newRandom() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(encoding.EncodeToString(randomBytes), "="), nil
+}
+
+func writeJSON(rw http.ResponseWriter, status int, data interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+
+	json.NewEncoder(rw).Encode(data)
+}
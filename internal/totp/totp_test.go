@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret used by the test vectors in appendix B of RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The expected codes are the last six digits of the eight digit codes given in
+	// the RFC.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", Code(rfcSecret, current), current, true},
+		{"previous step", Code(rfcSecret, current-1), current - 1, true},
+		{"next step", Code(rfcSecret, current+1), current + 1, true},
+		{"outside skew before", Code(rfcSecret, current-2), 0, false},
+		{"outside skew after", Code(rfcSecret, current+2), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", Code(rfcSecret, current)[:Digits-1], 0, false},
+		{"too long", Code(rfcSecret, current) + "0", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.code, rfcSecret, now)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("got (%d, %t), want (%d, %t)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 20 || string(a) == string(b) {
+		t.Errorf("got secrets %x and %x, want two different 20 byte secrets", a, b)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Sunrise", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Sunrise:alice@example.com" {
+		t.Errorf("unexpected URI %q", u)
+	}
+
+	qs := u.Query()
+
+	tests := []struct {
+		param string
+		want  string
+	}{
+		{"secret", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		{"issuer", "Sunrise"},
+		{"algorithm", "SHA1"},
+		{"digits", "6"},
+		{"period", "30"},
+	}
+
+	for _, tt := range tests {
+		if got := qs.Get(tt.param); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.param, got, tt.want)
+		}
+	}
+
+	if strings.Contains(EncodeSecret(rfcSecret), "=") {
+		t.Error("encoded secret must not be padded")
+	}
+}
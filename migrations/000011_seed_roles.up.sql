@@ -0,0 +1,12 @@
+INSERT INTO roles (name, description)
+VALUES
+    ('viewer', 'Can browse the movie catalogue'),
+    ('editor', 'Can browse and edit the movie catalogue'),
+    ('admin', 'Has every permission, including user management');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'movies:read')
+OR (roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write'))
+OR roles.name = 'admin';
@@ -0,0 +1,11 @@
+INSERT INTO permissions (code)
+VALUES
+    ('*'),
+    ('movies:*'),
+    ('metrics:*'),
+    ('users:*');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = '*';
@@ -0,0 +1,9 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS permissions;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS prefix;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS name;
+
+DELETE FROM tokens WHERE expiry IS NULL;
+
+ALTER TABLE tokens ALTER COLUMN expiry SET NOT NULL;
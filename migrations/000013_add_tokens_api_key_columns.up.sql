@@ -0,0 +1,7 @@
+ALTER TABLE tokens ALTER COLUMN expiry DROP NOT NULL;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS prefix text NOT NULL DEFAULT '';
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS permissions text[];
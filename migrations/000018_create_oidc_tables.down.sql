@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS oidc_logins;
+
+DROP TABLE IF EXISTS user_identities;
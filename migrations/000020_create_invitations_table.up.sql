@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    token_hash bytea UNIQUE NOT NULL REFERENCES tokens (hash) ON DELETE CASCADE,
+    email citext NOT NULL,
+    permissions text[] NOT NULL,
+    roles text[] NOT NULL
+);
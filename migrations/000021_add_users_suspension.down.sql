@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS suspension_reason;
+
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
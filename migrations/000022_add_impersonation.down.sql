@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS actor_id;
+
+DELETE FROM permissions WHERE code = 'users:impersonate';
@@ -0,0 +1,5 @@
+INSERT INTO permissions (code)
+VALUES
+    ('users:impersonate');
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS actor_id bigint REFERENCES users ON DELETE CASCADE;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_certificates (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    subject text UNIQUE NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_certificates_user_id_idx ON user_certificates (user_id);
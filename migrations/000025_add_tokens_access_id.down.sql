@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS access_expiry;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS access_id;